@@ -0,0 +1,239 @@
+package anim
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"strconv"
+	"strings"
+)
+
+// ErrNoDelays is returned when parsing an empty list of delays
+var ErrNoDelays = errors.New("anim: no delays given")
+
+// Animation is a sequence of full-size frames along with their timing
+type Animation struct {
+	// Frames are the fully composited frames of the animation
+	Frames []*image.RGBA
+	// Delay is the per-frame delay in 100ths of a second
+	Delay []int
+	// Disposal is the per-frame disposal method
+	Disposal []byte
+	// LoopCount controls the number of times the animation is played, as
+	// described for gif.GIF
+	LoopCount int
+}
+
+// Append adds a frame to the end of the animation. It is never disposed of,
+// as the next full-size frame covers it completely.
+func (a *Animation) Append(frame *image.RGBA, delay int) {
+	a.Frames = append(a.Frames, frame)
+	a.Delay = append(a.Delay, delay)
+	a.Disposal = append(a.Disposal, gif.DisposalNone)
+}
+
+// SetDelays sets the delay of every frame. A single delay is used for all
+// frames, while a list of delays is repeated over the frames in order.
+func (a *Animation) SetDelays(delays []int) {
+	if len(delays) == 0 {
+		return
+	}
+	a.Delay = make([]int, len(a.Frames))
+	for i := range a.Delay {
+		a.Delay[i] = delays[i%len(delays)]
+	}
+}
+
+// PingPong appends the frames in reverse so the animation plays forwards then
+// backwards. The first and last frames aren't repeated, so it loops smoothly.
+func (a *Animation) PingPong() {
+	for i := len(a.Frames) - 2; i > 0; i-- {
+		a.Frames = append(a.Frames, a.Frames[i])
+		if i < len(a.Delay) {
+			a.Delay = append(a.Delay, a.Delay[i])
+		}
+		if i < len(a.Disposal) {
+			a.Disposal = append(a.Disposal, a.Disposal[i])
+		}
+	}
+}
+
+// ParseDelays parses a comma separated list of delays in 100ths of a second
+func ParseDelays(s string) ([]int, error) {
+	var delays []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		delay, err := strconv.Atoi(field)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("anim: bad delay %q", field)
+		}
+		delays = append(delays, delay)
+	}
+	if len(delays) == 0 {
+		return nil, ErrNoDelays
+	}
+	return delays, nil
+}
+
+// ParseLoopCount parses "infinite", "once" or the number of times to play
+// the animation into a gif.GIF loop count
+func ParseLoopCount(s string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "infinite", "forever":
+		return 0, nil
+	case "once":
+		return -1, nil
+	}
+	plays, err := strconv.Atoi(s)
+	if err != nil || plays < 0 {
+		return 0, fmt.Errorf("anim: bad loop count %q", s)
+	}
+	switch plays {
+	case 0:
+		return 0, nil
+	case 1:
+		return -1, nil
+	}
+	return plays - 1, nil
+}
+
+// FromGIF composites the frames of a decoded GIF onto a full-size canvas, so
+// each frame can be glitched as a whole image. Delays, disposal methods and
+// the loop count are kept.
+func FromGIF(g *gif.GIF) *Animation {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+
+	a := &Animation{LoopCount: g.LoopCount}
+	canvas := image.NewRGBA(bounds)
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		composited := image.NewRGBA(bounds)
+		copy(composited.Pix, canvas.Pix)
+		a.Frames = append(a.Frames, composited)
+		a.Disposal = append(a.Disposal, disposal)
+		if i < len(g.Delay) {
+			a.Delay = append(a.Delay, g.Delay[i])
+		} else {
+			a.Delay = append(a.Delay, 0)
+		}
+
+		// Prepare the canvas for the next frame
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return a
+}
+
+// GIFOptions configures how frames are mapped onto GIF palettes
+type GIFOptions struct {
+	// NumColors is the most colours in each palette, from 1 to 256
+	NumColors int
+	// Quantizer builds the palettes. If nil, the Plan9 palette is used.
+	Quantizer draw.Quantizer
+	// Global builds one palette from every frame, rather than one per frame
+	Global bool
+	// Drawer maps frames onto the palette. If nil, Floyd-Steinberg is used.
+	Drawer draw.Drawer
+}
+
+// ToGIF converts the animation to a GIF. With nil options each frame is
+// mapped to the Plan9 palette with Floyd-Steinberg dithering. As every frame
+// is full size, the original disposal methods are still safe to use.
+func (a *Animation) ToGIF(opts *GIFOptions) *gif.GIF {
+	if opts == nil {
+		opts = &GIFOptions{}
+	}
+	numColors := opts.NumColors
+	if numColors < 1 || numColors > 256 {
+		numColors = 256
+	}
+	drawer := opts.Drawer
+	if drawer == nil {
+		drawer = draw.FloydSteinberg
+	}
+
+	var shared color.Palette
+	switch {
+	case opts.Quantizer == nil:
+		shared = palette.Plan9[:numColors]
+	case opts.Global && len(a.Frames) > 0:
+		shared = opts.Quantizer.Quantize(make(color.Palette, 0, numColors), stack(a.Frames))
+	}
+
+	g := &gif.GIF{LoopCount: a.LoopCount}
+	for i, frame := range a.Frames {
+		bounds := frame.Bounds()
+		p := shared
+		if p == nil {
+			p = opts.Quantizer.Quantize(make(color.Palette, 0, numColors), frame)
+		}
+		palettedImage := image.NewPaletted(bounds, p)
+		drawer.Draw(palettedImage, bounds, frame, bounds.Min)
+
+		var delay int
+		var disposal byte
+		if i < len(a.Delay) {
+			delay = a.Delay[i]
+		}
+		if i < len(a.Disposal) {
+			disposal = a.Disposal[i]
+		}
+
+		g.Image = append(g.Image, palettedImage)
+		g.Delay = append(g.Delay, delay)
+		g.Disposal = append(g.Disposal, disposal)
+	}
+
+	// Write a single global colour table when every frame shares a palette
+	if opts.Global && shared != nil && len(a.Frames) > 0 {
+		bounds := a.Frames[0].Bounds()
+		g.Config = image.Config{ColorModel: shared, Width: bounds.Dx(), Height: bounds.Dy()}
+	}
+	return g
+}
+
+// stack joins the frames top to bottom into one image, so a quantizer can
+// build a single palette for all of them
+func stack(frames []*image.RGBA) image.Image {
+	var height, width int
+	for _, frame := range frames {
+		height += frame.Bounds().Dy()
+		if w := frame.Bounds().Dx(); w > width {
+			width = w
+		}
+	}
+
+	stacked := image.NewRGBA(image.Rect(0, 0, width, height))
+	y := 0
+	for _, frame := range frames {
+		bounds := frame.Bounds()
+		draw.Draw(stacked, image.Rect(0, y, bounds.Dx(), y+bounds.Dy()), frame, bounds.Min, draw.Src)
+		y += bounds.Dy()
+	}
+	return stacked
+}
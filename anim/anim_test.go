@@ -0,0 +1,79 @@
+package anim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseDelays(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		err  bool
+	}{
+		{"10", []int{10}, false},
+		{"10,20, 30", []int{10, 20, 30}, false},
+		{"0,5,", []int{0, 5}, false},
+		{"", nil, true},
+		{" , ", nil, true},
+		{"10,x", nil, true},
+		{"-1", nil, true},
+		{"1.5", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDelays(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseDelays(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDelays(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseDelays(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := ParseDelays(""); !errors.Is(err, ErrNoDelays) {
+		t.Errorf("ParseDelays(\"\") = %v, want ErrNoDelays", err)
+	}
+}
+
+func TestParseLoopCount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		err  bool
+	}{
+		{"infinite", 0, false},
+		{"Forever", 0, false},
+		{"once", -1, false},
+		{"0", 0, false},
+		{"1", -1, false},
+		{"2", 1, false},
+		{"10", 9, false},
+		{"", 0, true},
+		{"-2", 0, true},
+		{"twice", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLoopCount(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseLoopCount(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLoopCount(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLoopCount(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
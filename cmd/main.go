@@ -1,11 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"flag"
 	"fmt"
 	"image"
-	"image/color/palette"
 	"image/draw"
 	"image/gif"
 	"image/jpeg"
@@ -14,13 +15,28 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/darkliquid/glitch"
+	"github.com/darkliquid/glitch/anim"
+	"github.com/darkliquid/glitch/databend"
+	"github.com/darkliquid/glitch/dither"
+	"github.com/darkliquid/glitch/effects"
+	"github.com/darkliquid/glitch/mask"
+	"github.com/darkliquid/glitch/quantize"
+	"github.com/darkliquid/glitch/utils"
 )
 
+// How many times to re-corrupt input that won't decode when databending
+const databendTries = 32
+
+// Delay between generated frames in 100ths of a second, when -delay isn't given
+const defaultDelay = 10
+
 // Custom usage info func for flags package
 func usage() {
-	fmt.Fprintln(os.Stderr, "Usage: glitch [-gbls] input_image output_image")
+	fmt.Fprintln(os.Stderr, "Usage: glitch [-gblsfmr] input_image output_image")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -31,6 +47,19 @@ func bail(message string) {
 	os.Exit(1)
 }
 
+// parseInts parses a list of whole numbers separated by sep
+func parseInts(s, sep string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(s, sep) {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q in %q", field, s)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
 // Generates a random int64 seed value from the seed string
 func randomseed(seed string) (seedInt int64) {
 	hasher := md5.New()
@@ -48,15 +77,91 @@ func randomseed(seed string) (seedInt int64) {
 	return
 }
 
+// isFlagSet reports whether the named flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Converts an image to RGBA, if it isn't already
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// loadMask returns a function giving the mask for each image, either from a
+// mask image file or from a shape or generated mask description
+func loadMask(spec string) (func(img image.Image) (image.Image, error), error) {
+	f, err := os.Open(spec)
+	if err != nil {
+		// Only a missing file named like a shape or generated mask is
+		// parsed as one, so a mistyped file name is reported as missing
+		if !os.IsNotExist(err) || !mask.IsSpec(spec) {
+			return nil, err
+		}
+		if _, err := mask.ParseImage(spec, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+			return nil, err
+		}
+		return func(img image.Image) (image.Image, error) {
+			return mask.ParseImage(spec, img)
+		}, nil
+	}
+	defer f.Close()
+	maskImg, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return func(image.Image) (image.Image, error) {
+		return maskImg, nil
+	}, nil
+}
+
 // Main
 func main() {
 	var seed string
 	var glitchFactor float64
 	var brightnessFactor float64
 	var useScanLines bool
+	var scanPeriod int
+	var scanThickness int
+	var scanPhase int
+	var scanOpacity float64
+	var scanBlend string
+	var scanColor string
+	var scanOrientation string
+	var scanJitter int
+	var vhs float64
+	var crt string
+	var pixelate string
+	var pixelSample bool
+	var pixelLevels int
+	var pixelThreshold string
 	var inputImage string
 	var outputImage string
 	var frames int
+	var mode string
+	var recipe string
+	var traceFile string
+	var replayFile string
+	var bend int
+	var mosh bool
+	var coherent bool
+	var delay string
+	var loop string
+	var pingPong bool
+	var colors int
+	var globalPalette bool
+	var maskSpec string
 	var debug bool
 
 	// Setup usage info
@@ -80,6 +185,28 @@ func main() {
 	flag.BoolVar(&useScanLines, "scanlines", true, "Apply the scan line filter")
 	flag.BoolVar(&useScanLines, "l", true, "Apply the scan line filter - shorthand syntax")
 
+	// Scan line pattern
+	flag.IntVar(&scanPeriod, "scanperiod", 2, "Distance in pixels from one scan line to the next")
+	flag.IntVar(&scanThickness, "scanthickness", 1, "Thickness of each scan line in pixels")
+	flag.IntVar(&scanPhase, "scanphase", 0, "Move the scan lines along by this many pixels")
+	flag.Float64Var(&scanOpacity, "scanopacity", 1, "Opacity of the scan lines (0-1)")
+	flag.StringVar(&scanBlend, "scanblend", "normal", "How the scan lines are blended: normal, multiply or screen")
+	flag.StringVar(&scanColor, "scancolor", "000000", "Colour of the scan lines as RRGGBB")
+	flag.StringVar(&scanOrientation, "scanorientation", "horizontal", "Direction of the scan lines: horizontal, vertical or diagonal")
+	flag.IntVar(&scanJitter, "scanjitter", 0, "Shift every other row this many pixels, like a badly interlaced picture")
+
+	// Pixelation
+	flag.StringVar(&pixelate, "pixelate", "", "Pixelate the result into blocks of this size, as N or WxH")
+	flag.BoolVar(&pixelSample, "pixelsample", false, "Colour each pixelated block by the pixel at its centre rather than its average")
+	flag.IntVar(&pixelLevels, "pixellevels", 0, "Quantise each channel of the pixelated blocks to this many levels (2-256) instead of thresholding it")
+	flag.StringVar(&pixelThreshold, "pixelthreshold", "128", "Threshold for each channel of the pixelated blocks, as N or R,G,B")
+
+	// VHS tape playback
+	flag.Float64Var(&vhs, "vhs", 0, "Play the result back from a worn out video tape this much (0-100), frame by frame for animations")
+
+	// CRT display
+	flag.StringVar(&crt, "crt", "", "Show the result on a CRT with this phosphor mask (aperture, shadow, slot or none), in place of the scan line filter")
+
 	// A seed to use for the randomiser
 	flag.StringVar(&seed, "seed", hostname, "Seed for the randomiser")
 	flag.StringVar(&seed, "s", hostname, "Seed for the randomiser - shorthand syntax")
@@ -88,6 +215,40 @@ func main() {
 	flag.IntVar(&frames, "frames", 0, "Number of frames (only valid for gif output)")
 	flag.IntVar(&frames, "f", 0, "Number of frames (only valid for gif output) - shorthand syntax")
 
+	// Glitch algorithm
+	modeUsage := "Glitch algorithm to use (" + strings.Join(glitch.Modes(), ", ") + ")"
+	flag.StringVar(&mode, "mode", glitch.DefaultMode, modeUsage)
+	flag.StringVar(&mode, "m", glitch.DefaultMode, modeUsage+" - shorthand syntax")
+
+	// Recipe
+	flag.StringVar(&recipe, "recipe", "", "JSON recipe of pipeline steps to run instead of the -mode algorithm")
+	flag.StringVar(&recipe, "r", "", "JSON recipe of pipeline steps to run instead of the -mode algorithm - shorthand syntax")
+
+	// Datamoshing
+	flag.BoolVar(&mosh, "mosh", false, "Datamosh each frame against the previous one instead of glitching it afresh (only valid for gif output)")
+
+	// Animation timing
+	flag.StringVar(&delay, "delay", "", "Frame delay in 100ths of a second, or a comma separated list of delays to cycle through (only valid for gif output)")
+	flag.StringVar(&loop, "loop", "", "How many times to play the animation: infinite, once or a number (only valid for gif output)")
+	flag.BoolVar(&pingPong, "pingpong", false, "Play the animation forwards then backwards (only valid for gif output)")
+
+	// Palettes
+	flag.IntVar(&colors, "colors", 256, "Number of colours in each gif palette (2-256)")
+	flag.BoolVar(&globalPalette, "globalpalette", false, "Build one palette for all frames of an animated gif, rather than one per frame")
+
+	// Temporal coherence
+	flag.BoolVar(&coherent, "coherent", false, "Make the same random choices for every frame of an animated gif input, so the glitch doesn't flicker")
+
+	// Data bending
+	flag.IntVar(&bend, "databend", 0, "Number of bytes of the encoded input to corrupt before decoding (JPEG or PNG input only)")
+
+	// Masking
+	flag.StringVar(&maskSpec, "mask", "", "Only glitch where this grayscale image, shape such as ellipse:0.2,0.2,0.8,0.8 or generated mask such as luminance:0.7,1 is white")
+
+	// Traces
+	flag.StringVar(&traceFile, "trace", "", "Record the random choices of the first frame to this JSON file")
+	flag.StringVar(&replayFile, "replay", "", "Replay the random choices recorded in this JSON trace file")
+
 	// Debug
 	flag.BoolVar(&debug, "debug", false, "Enable debug info")
 
@@ -104,28 +265,183 @@ func main() {
 	case len(outputImage) == 0:
 		fmt.Fprintln(os.Stderr, "No output image specified")
 		usage()
-	case glitchFactor > 100.0 || glitchFactor < 0.0:
-		fmt.Fprintln(os.Stderr, "Glitch factor must be between 0 and 100")
-		usage()
-	case brightnessFactor > 100.0 || brightnessFactor < 0.0:
-		fmt.Fprintln(os.Stderr, "Brightness factor must be between 0 and 100")
-		usage()
 	case frames > 1 && filepath.Ext(outputImage) != ".gif":
 		fmt.Fprintln(os.Stderr, "Frames > 1 is only valid for gifs")
 		usage()
+	case colors < 2 || colors > 256:
+		fmt.Fprintln(os.Stderr, "Colors must be between 2 and 256")
+		usage()
+	case len(recipe) > 0 && (len(traceFile) > 0 || len(replayFile) > 0):
+		fmt.Fprintln(os.Stderr, "Traces can't be used with recipes")
+		usage()
+	case len(recipe) > 0 && (isFlagSet("mode") || isFlagSet("m")):
+		fmt.Fprintln(os.Stderr, "Recipes can't be used with a mode")
+		usage()
+	case len(recipe) > 0 && !mosh && (isFlagSet("glitch") || isFlagSet("g")):
+		fmt.Fprintln(os.Stderr, "Recipes set the glitch factor of each step, so -glitch is only used with -mosh")
+		usage()
 	}
 
-	glitch.Debug = debug
+	// Animation settings, which override those of animated input
+	var delays []int
+	if len(delay) > 0 {
+		var err error
+		if delays, err = anim.ParseDelays(delay); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			usage()
+		}
+	}
+	loopCount := 0
+	if len(loop) > 0 {
+		var err error
+		if loopCount, err = anim.ParseLoopCount(loop); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			usage()
+		}
+	}
+	applyAnimFlags := func(a *anim.Animation) {
+		a.SetDelays(delays)
+		if len(loop) > 0 {
+			a.LoopCount = loopCount
+		}
+		if pingPong {
+			a.PingPong()
+		}
+	}
 
-	// Seed the random number generator
-	rand.Seed(randomseed(seed))
+	gifOpts := &anim.GIFOptions{
+		NumColors: colors,
+		Quantizer: quantize.MedianCut{},
+		Global:    globalPalette,
+	}
 
-	// Prep writing the output file
-	writer, err := os.Create(outputImage)
-	if err != nil {
-		bail("Couldn't create output file!")
+	// Recipes have their own brightness and scanlines steps, so only add more
+	// when asked to
+	if len(recipe) > 0 {
+		if !isFlagSet("brightness") && !isFlagSet("b") {
+			brightnessFactor = 0
+		}
+		if !isFlagSet("scanlines") && !isFlagSet("l") {
+			// Setting up the scan line pattern asks for them too
+			useScanLines = false
+			for _, name := range []string{"scanperiod", "scanthickness", "scanphase", "scanopacity", "scanblend", "scancolor", "scanorientation", "scanjitter"} {
+				if isFlagSet(name) {
+					useScanLines = true
+				}
+			}
+		}
+	}
+
+	seedInt := randomseed(seed)
+	opts := glitch.Options{
+		GlitchFactor:     glitchFactor,
+		BrightnessFactor: brightnessFactor,
+		ScanLines:        useScanLines,
+		VHS:              vhs,
+		Mode:             mode,
+		// One source for the whole run so each frame gets a fresh glitch
+		Rand: rand.New(rand.NewSource(seedInt)),
+	}
+	if useScanLines {
+		style := effects.ScanlineOptions{
+			Period:    scanPeriod,
+			Thickness: scanThickness,
+			Phase:     scanPhase,
+			Opacity:   scanOpacity,
+			Jitter:    scanJitter,
+		}
+		var ok bool
+		if style.Blend, ok = effects.BlendModes[scanBlend]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown scan line blend mode %q\n", scanBlend)
+			usage()
+		}
+		if style.Orientation, ok = effects.ScanlineOrientations[scanOrientation]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown scan line orientation %q\n", scanOrientation)
+			usage()
+		}
+		if style.Color, err = utils.ParseHexColor(scanColor); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			usage()
+		}
+		opts.ScanlineStyle = &style
+	}
+	if len(pixelate) > 0 {
+		size, err := parseInts(pixelate, "x")
+		if err == nil && len(size) > 2 {
+			err = fmt.Errorf("pixelate size %q isn't N or WxH", pixelate)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			usage()
+		}
+		thresholds, err := parseInts(pixelThreshold, ",")
+		if err == nil && len(thresholds) != 1 && len(thresholds) != 3 {
+			err = fmt.Errorf("pixel threshold %q isn't N or R,G,B", pixelThreshold)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			usage()
+		}
+		opts.Pixelate = &dither.Pixelate{
+			Width:     size[0],
+			Height:    size[len(size)-1],
+			Sample:    pixelSample,
+			Levels:    pixelLevels,
+			Threshold: thresholds[0],
+		}
+		if len(thresholds) == 3 {
+			opts.Pixelate.Thresholds = thresholds
+		}
+	}
+	if len(crt) > 0 {
+		crtOpts := effects.DefaultCRT()
+		var ok bool
+		if crtOpts.Mask, ok = effects.PhosphorMasks[crt]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown phosphor mask %q\n", crt)
+			usage()
+		}
+		opts.CRT = &crtOpts
+	}
+	if len(recipe) > 0 {
+		if opts.Pipeline, err = glitch.LoadPipelineFile(recipe); err != nil {
+			bail(fmt.Sprintf("Couldn't load recipe: %v", err))
+		}
+	}
+	if len(replayFile) > 0 {
+		trace, err := glitch.LoadTraceFile(replayFile)
+		if err != nil {
+			bail(fmt.Sprintf("Couldn't load trace: %v", err))
+		}
+		opts.Trace = trace
+	}
+	if err := opts.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+	}
+
+	glitch.Debug = debug
+	ctx := context.Background()
+
+	// Pick what to render each frame with
+	render := func(img image.Image) (image.Image, error) {
+		return glitch.Apply(ctx, img, opts)
+	}
+	if len(traceFile) > 0 {
+		render = func(img image.Image) (image.Image, error) {
+			outputImg, trace, err := glitch.Record(ctx, img, opts)
+			if err != nil {
+				return nil, err
+			}
+			if len(traceFile) > 0 {
+				if err := trace.SaveFile(traceFile); err != nil {
+					return nil, err
+				}
+				// Only the first frame is recorded
+				traceFile = ""
+			}
+			return outputImg, nil
+		}
 	}
-	defer writer.Close()
 
 	// Onto the main event!
 	reader, err := os.Open(inputImage)
@@ -134,12 +450,86 @@ func main() {
 	}
 	defer reader.Close()
 
-	inputImg, _, err := image.Decode(reader)
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		bail("Couldn't read input file!")
+	}
+
+	var inputImg image.Image
+	var inputAnim *anim.Animation
+	if bend > 0 {
+		inputImg, err = databend.Decode(data, opts.Rand, bend, databendTries)
+		if err != nil {
+			bail(fmt.Sprintf("Couldn't databend input file: %v", err))
+		}
+	} else if g, err := gif.DecodeAll(bytes.NewReader(data)); err == nil && len(g.Image) > 1 {
+		inputAnim = anim.FromGIF(g)
+		inputImg = inputAnim.Frames[0]
+	} else {
+		inputImg, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			bail("Couldn't decode input file!")
+		}
+	}
+
+	// Limit the glitch to a mask file, a shape or a mask generated from the input
+	var maskFor func(img image.Image) (image.Image, error)
+	if len(maskSpec) > 0 {
+		if maskFor, err = loadMask(maskSpec); err != nil {
+			bail(fmt.Sprintf("Couldn't load mask: %v", err))
+		}
+		if opts.Mask, err = maskFor(inputImg); err != nil {
+			bail(fmt.Sprintf("Couldn't load mask: %v", err))
+		}
+	}
+
+	// Prep writing the output file
+	writer, err := os.Create(outputImage)
 	if err != nil {
-		bail("Couldn't decode input file!")
+		bail("Couldn't create output file!")
 	}
+	defer writer.Close()
+
+	// Glitch every frame of animated input, if we're writing an animation
+	if inputAnim != nil && filepath.Ext(outputImage) == ".gif" {
+		for i, frame := range inputAnim.Frames {
+			if coherent {
+				opts.Rand = rand.New(rand.NewSource(seedInt))
+			}
+			if maskFor != nil {
+				// Generated masks follow the content of each frame
+				if opts.Mask, err = maskFor(frame); err != nil {
+					bail(fmt.Sprintf("Couldn't load mask: %v", err))
+				}
+			}
 
-	outputImg := glitch.Glitchify(inputImg, glitchFactor, brightnessFactor, useScanLines)
+			outputImg, err := render(frame)
+			if err != nil {
+				bail("Couldn't glitch input file!")
+			}
+			inputAnim.Frames[i] = toRGBA(outputImg)
+
+			if mosh && i > 0 {
+				moshed := image.NewRGBA(frame.Bounds())
+				effects.Datamosh(opts.Rand, moshed, inputAnim.Frames[i], inputAnim.Frames[i-1], glitch.DatamoshOptions(frame.Bounds(), glitchFactor))
+				if opts.Mask != nil {
+					effects.ApplyMask(moshed, frame, opts.Mask)
+				}
+				inputAnim.Frames[i] = moshed
+			}
+		}
+
+		applyAnimFlags(inputAnim)
+		if err := gif.EncodeAll(writer, inputAnim.ToGIF(gifOpts)); err != nil {
+			bail("Couldn't encode image")
+		}
+		return
+	}
+
+	outputImg, err := render(inputImg)
+	if err != nil {
+		bail("Couldn't glitch input file!")
+	}
 
 	// Pass off image writing to appropriate encoder
 	switch filepath.Ext(outputImage) {
@@ -147,38 +537,39 @@ func main() {
 		err = jpeg.Encode(writer, outputImg, &jpeg.Options{Quality: jpeg.DefaultQuality})
 	case ".gif":
 		if frames > 1 {
-			outGif := &gif.GIF{}
+			outAnim := &anim.Animation{}
 			bounds := inputImg.Bounds()
 
-			palettedImage := image.NewPaletted(bounds, palette.Plan9[:256])
-			draw.FloydSteinberg.Draw(palettedImage, bounds, inputImg, image.ZP)
+			// The untouched input is the first frame
+			outAnim.Append(toRGBA(inputImg), defaultDelay)
 
-			// Add new frame to animated GIF
-			outGif.Image = append(outGif.Image, palettedImage)
-			outGif.Delay = append(outGif.Delay, 0)
+			for i := 1; i < frames; i++ {
+				outAnim.Append(toRGBA(outputImg), defaultDelay)
 
-			frames--
-
-			for {
-				// We need paletted images for gifs, so convert
-				palettedImage = image.NewPaletted(bounds, palette.Plan9[:256])
-				draw.FloydSteinberg.Draw(palettedImage, bounds, outputImg, image.ZP)
-
-				// Add new frame to animated GIF
-				outGif.Image = append(outGif.Image, palettedImage)
-				outGif.Delay = append(outGif.Delay, 0)
-
-				frames--
-
-				if frames == 0 {
+				if i == frames-1 {
 					break
 				}
 
-				outputImg = glitch.Glitchify(inputImg, glitchFactor, brightnessFactor, useScanLines)
+				if mosh {
+					// Carry on from the previous frame like a P-frame with broken motion vectors
+					moshed := image.NewRGBA(bounds)
+					effects.Datamosh(opts.Rand, moshed, toRGBA(inputImg), toRGBA(outputImg), glitch.DatamoshOptions(bounds, glitchFactor))
+					if opts.Mask != nil {
+						effects.ApplyMask(moshed, toRGBA(inputImg), opts.Mask)
+					}
+					outputImg = moshed
+					continue
+				}
+
+				outputImg, err = render(inputImg)
+				if err != nil {
+					bail("Couldn't glitch input file!")
+				}
 			}
-			err = gif.EncodeAll(writer, outGif)
+			applyAnimFlags(outAnim)
+			err = gif.EncodeAll(writer, outAnim.ToGIF(gifOpts))
 		} else {
-			err = gif.Encode(writer, outputImg, &gif.Options{NumColors: 256})
+			err = gif.Encode(writer, outputImg, &gif.Options{NumColors: colors, Quantizer: gifOpts.Quantizer})
 		}
 	case ".png":
 		err = png.Encode(writer, outputImg)
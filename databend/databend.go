@@ -0,0 +1,251 @@
+package databend
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"io"
+
+	// Register the decoders Decode can bend
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/darkliquid/glitch/utils"
+)
+
+var (
+	// ErrUnsupported is returned for data that isn't a JPEG or PNG
+	ErrUnsupported = errors.New("databend: unsupported image format")
+	// ErrNoData is returned when there is no image data to corrupt
+	ErrNoData = errors.New("databend: no image data found")
+	// ErrTooBroken is returned when every attempt produced an undecodable image
+	ErrTooBroken = errors.New("databend: couldn't produce a decodable image")
+)
+
+// jpegScanPadding is the number of zero bytes appended to each JPEG scan
+const jpegScanPadding = 4096
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Bend corrupts amount bytes of the encoded image data, detecting whether
+// it is a JPEG or PNG. Headers and markers are left intact.
+func Bend(data []byte, rng utils.Rand, amount int) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return JPEG(data, rng, amount)
+	case bytes.HasPrefix(data, pngSignature):
+		return PNG(data, rng, amount)
+	}
+	return nil, ErrUnsupported
+}
+
+// Decode bends the data and decodes the result, trying again with fresh
+// corruption up to tries times if the bent data won't decode
+func Decode(data []byte, rng utils.Rand, amount, tries int) (image.Image, error) {
+	return DecodeContext(context.Background(), data, rng, amount, tries)
+}
+
+// DecodeContext is like Decode, but gives up and returns ctx.Err() if ctx is
+// cancelled between tries
+func DecodeContext(ctx context.Context, data []byte, rng utils.Rand, amount, tries int) (image.Image, error) {
+	for i := 0; i < tries; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		bent, err := Bend(data, rng, amount)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(bytes.NewReader(bent))
+		if err == nil {
+			return img, nil
+		}
+	}
+	return nil, ErrTooBroken
+}
+
+// JPEG corrupts amount bytes of the entropy coded scan data of a JPEG. Marker
+// segments are skipped, and no byte is changed to or from 0xFF so the marker
+// structure of the file survives. Each scan is padded with zeros so decoders
+// thrown out of sync don't run out of data.
+func JPEG(data []byte, rng utils.Rand, amount int) ([]byte, error) {
+	var candidates, scanEnds []int
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return nil, ErrNoData
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xd9:
+			// End of image
+			i = len(data)
+			continue
+		case marker == 0xff:
+			// Fill byte
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// Markers without a length
+			i += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		i += 2 + length
+		if marker != 0xda {
+			continue
+		}
+
+		// Start of scan, so entropy coded data follows until the next
+		// marker that isn't a stuffed byte or a restart
+		for i < len(data) {
+			if data[i] == 0xff && i+1 < len(data) {
+				next := data[i+1]
+				if next != 0x00 && (next < 0xd0 || next > 0xd7) {
+					break
+				}
+				i += 2
+				continue
+			}
+			candidates = append(candidates, i)
+			i++
+		}
+		scanEnds = append(scanEnds, i)
+	}
+
+	if len(candidates) == 0 {
+		return nil, ErrNoData
+	}
+
+	corrupted := make([]byte, len(data))
+	copy(corrupted, data)
+	for n := 0; n < amount; n++ {
+		i := candidates[utils.Random(rng, 0, len(candidates))]
+		corrupted[i] = byte(utils.Random(rng, 0, 0xff))
+	}
+
+	// Corrupt huffman codes often need more bits than the scan has left,
+	// so pad each scan with zeros for the decoder to run on into. Decoders
+	// skip any padding they don't use when looking for the next marker.
+	bent := make([]byte, 0, len(data)+len(scanEnds)*jpegScanPadding)
+	last := 0
+	for _, end := range scanEnds {
+		bent = append(bent, corrupted[last:end]...)
+		bent = append(bent, make([]byte, jpegScanPadding)...)
+		last = end
+	}
+	return append(bent, corrupted[last:]...), nil
+}
+
+// PNG decompresses the IDAT chunks of a PNG, corrupts amount bytes of the
+// pixel data and writes it back. For non-interlaced images the per-row
+// filter bytes are left alone.
+func PNG(data []byte, rng utils.Rand, amount int) ([]byte, error) {
+	type chunk struct {
+		kind string
+		data []byte
+	}
+	var chunks []chunk
+	var idat bytes.Buffer
+	var rowBytes int
+
+	for i := len(pngSignature); i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if i+12+length > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		kind := string(data[i+4 : i+8])
+		body := data[i+8 : i+8+length]
+		i += 12 + length
+
+		switch kind {
+		case "IHDR":
+			if len(body) < 13 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			rowBytes = pngRowBytes(body)
+		case "IDAT":
+			idat.Write(body)
+			// Keep a single placeholder to rewrite the pixel data into
+			if len(chunks) > 0 && chunks[len(chunks)-1].kind == "IDAT" {
+				continue
+			}
+		}
+		chunks = append(chunks, chunk{kind, body})
+	}
+
+	zr, err := zlib.NewReader(&idat)
+	if err != nil {
+		return nil, err
+	}
+	pixels, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	if len(pixels) == 0 {
+		return nil, ErrNoData
+	}
+
+	for n := 0; n < amount; n++ {
+		i := utils.Random(rng, 0, len(pixels))
+		if rowBytes > 0 && i%rowBytes == 0 {
+			continue
+		}
+		pixels[i] = byte(utils.Random(rng, 0, 256))
+	}
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(pixels)
+	zw.Close()
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	for _, c := range chunks {
+		body := c.data
+		if c.kind == "IDAT" {
+			body = compressed.Bytes()
+		}
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(body)))
+		copy(header[4:], c.kind)
+		out.Write(header[:])
+		out.Write(body)
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+		out.Write(sum[:])
+	}
+	return out.Bytes(), nil
+}
+
+// pngRowBytes returns the length of a filtered row including its filter
+// byte, or 0 for interlaced images where rows vary in length
+func pngRowBytes(ihdr []byte) int {
+	width := int(binary.BigEndian.Uint32(ihdr))
+	depth := int(ihdr[8])
+	interlaced := ihdr[12] != 0
+	if interlaced {
+		return 0
+	}
+
+	channels := 1
+	switch ihdr[9] {
+	case 2:
+		channels = 3
+	case 4:
+		channels = 2
+	case 6:
+		channels = 4
+	}
+	return 1 + (width*channels*depth+7)/8
+}
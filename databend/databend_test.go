@@ -0,0 +1,112 @@
+package databend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// encoded returns a small test image encoded as a JPEG and as a PNG
+func encoded(t *testing.T) (jpg, pn []byte) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 5), uint8(y * 7), uint8((x + y) * 3), 0xff})
+		}
+	}
+	var j, p bytes.Buffer
+	if err := jpeg.Encode(&j, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&p, img); err != nil {
+		t.Fatal(err)
+	}
+	return j.Bytes(), p.Bytes()
+}
+
+func TestBend(t *testing.T) {
+	jpg, pn := encoded(t)
+	tests := []struct {
+		name   string
+		data   []byte
+		bend   func([]byte, *rand.Rand) ([]byte, error)
+		format string
+	}{
+		{"jpeg", jpg, func(d []byte, r *rand.Rand) ([]byte, error) { return JPEG(d, r, 20) }, "jpeg"},
+		{"png", pn, func(d []byte, r *rand.Rand) ([]byte, error) { return PNG(d, r, 200) }, "png"},
+		{"bend jpeg", jpg, func(d []byte, r *rand.Rand) ([]byte, error) { return Bend(d, r, 20) }, "jpeg"},
+		{"bend png", pn, func(d []byte, r *rand.Rand) ([]byte, error) { return Bend(d, r, 200) }, "png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]byte(nil), tt.data...)
+			bent, err := tt.bend(tt.data, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tt.data, original) {
+				t.Error("the input data was modified")
+			}
+
+			img, format, err := image.Decode(bytes.NewReader(bent))
+			if err != nil {
+				t.Fatalf("bent data doesn't decode: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("decoded as %s, want %s", format, tt.format)
+			}
+			if img.Bounds() != image.Rect(0, 0, 48, 32) {
+				t.Errorf("decoded bounds are %v", img.Bounds())
+			}
+
+			again, err := tt.bend(tt.data, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(bent, again) {
+				t.Error("the same seed bent the data differently")
+			}
+		})
+	}
+}
+
+func TestBendErrors(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrUnsupported},
+		{"gif", []byte("GIF89a"), ErrUnsupported},
+		{"jpeg without a scan", []byte{0xff, 0xd8, 0xff, 0xd9}, ErrNoData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Bend(tt.data, rng, 10); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	jpg, pn := encoded(t)
+	for name, data := range map[string][]byte{"jpeg": jpg, "png": pn} {
+		t.Run(name, func(t *testing.T) {
+			img, err := Decode(data, rand.New(rand.NewSource(1)), 20, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds() != image.Rect(0, 0, 48, 32) {
+				t.Errorf("decoded bounds are %v", img.Bounds())
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+)
+
+// Spread is the share of a pixel's error passed to the neighbour at (DX, DY)
+type Spread struct {
+	DX, DY int
+	Weight float64
+}
+
+// Kernel is an error diffusion kernel, listing how a pixel's error is spread
+// over the pixels after it
+type Kernel []Spread
+
+// NewKernel builds a kernel from a matrix of weights and a divisor. The first
+// row of the matrix is the current row, with the current pixel in column
+// originX. Weights at or before the current pixel are ignored.
+func NewKernel(matrix [][]float64, divisor float64, originX int) Kernel {
+	var kernel Kernel
+	for dy, row := range matrix {
+		for x, weight := range row {
+			dx := x - originX
+			if weight == 0 || (dy == 0 && dx <= 0) {
+				continue
+			}
+			kernel = append(kernel, Spread{DX: dx, DY: dy, Weight: weight / divisor})
+		}
+	}
+	return kernel
+}
+
+// Classic error diffusion kernels
+var (
+	// FloydSteinbergKernel spreads error like this:
+	//   * 7
+	// 3 5 1   (/16)
+	FloydSteinbergKernel = NewKernel([][]float64{
+		{0, 0, 7},
+		{3, 5, 1},
+	}, 16, 1)
+
+	// AtkinsonKernel only spreads 3/4 of the error, for a high contrast look:
+	//   * 1 1
+	// 1 1 1
+	//   1     (/8)
+	AtkinsonKernel = NewKernel([][]float64{
+		{0, 0, 1, 1},
+		{1, 1, 1, 0},
+		{0, 1, 0, 0},
+	}, 8, 1)
+
+	// BrokenAtkinsonKernel has the taps of the original Atkinsons dither,
+	// which passed the error meant for the pixel down and to the right one
+	// pixel further along. Broken diffusion uses it for Atkinsons, so it looks
+	// the same as it always has.
+	//   * 1 1
+	// 1 1   1
+	//   1     (/8)
+	BrokenAtkinsonKernel = NewKernel([][]float64{
+		{0, 0, 1, 1},
+		{1, 1, 0, 1},
+		{0, 1, 0, 0},
+	}, 8, 1)
+
+	// JarvisJudiceNinkeKernel spreads error over three rows (/48)
+	JarvisJudiceNinkeKernel = NewKernel([][]float64{
+		{0, 0, 0, 7, 5},
+		{3, 5, 7, 5, 3},
+		{1, 3, 5, 3, 1},
+	}, 48, 2)
+
+	// StuckiKernel is a sharper variant of Jarvis-Judice-Ninke (/42)
+	StuckiKernel = NewKernel([][]float64{
+		{0, 0, 0, 8, 4},
+		{2, 4, 8, 4, 2},
+		{1, 2, 4, 2, 1},
+	}, 42, 2)
+
+	// BurkesKernel is a two row simplification of Stucki (/32)
+	BurkesKernel = NewKernel([][]float64{
+		{0, 0, 0, 8, 4},
+		{2, 4, 8, 4, 2},
+	}, 32, 2)
+
+	// SierraKernel is the three row Sierra kernel (/32)
+	SierraKernel = NewKernel([][]float64{
+		{0, 0, 0, 5, 3},
+		{2, 4, 5, 4, 2},
+		{0, 2, 3, 2, 0},
+	}, 32, 2)
+
+	// TwoRowSierraKernel is the two row Sierra kernel (/16)
+	TwoRowSierraKernel = NewKernel([][]float64{
+		{0, 0, 0, 4, 3},
+		{1, 2, 3, 2, 1},
+	}, 16, 2)
+
+	// SierraLiteKernel is the smallest Sierra kernel (/4)
+	SierraLiteKernel = NewKernel([][]float64{
+		{0, 0, 2},
+		{1, 1, 0},
+	}, 4, 1)
+
+	// StevensonArceKernel is designed for hexagonal grids and spreads error
+	// to every other pixel (/200)
+	StevensonArceKernel = NewKernel([][]float64{
+		{0, 0, 0, 0, 0, 32, 0},
+		{12, 0, 26, 0, 30, 0, 16},
+		{0, 12, 0, 26, 0, 12, 0},
+		{5, 0, 12, 0, 12, 0, 5},
+	}, 200, 3)
+)
+
+// Kernels maps the names of the classic kernels to the kernels
+var Kernels = map[string]Kernel{
+	"floydsteinberg":    FloydSteinbergKernel,
+	"atkinsons":         AtkinsonKernel,
+	"jarvisjudiceninke": JarvisJudiceNinkeKernel,
+	"stucki":            StuckiKernel,
+	"burkes":            BurkesKernel,
+	"sierra":            SierraKernel,
+	"tworowsierra":      TwoRowSierraKernel,
+	"sierralite":        SierraLiteKernel,
+	"stevensonarce":     StevensonArceKernel,
+}
+
+// Diffuser is a configurable error diffusion ditherer
+type Diffuser struct {
+	// Kernel spreads each pixel's error to its neighbours
+	Kernel Kernel
+	// Palette is the set of colours to map pixels to, matched perceptually.
+	// If it is empty, each channel is instead set to 0 or 255 depending on
+	// Threshold.
+	Palette color.Palette
+	// Threshold is the per-channel threshold used when Palette is empty
+	Threshold uint8
+	// Serpentine scans alternate rows right to left, which reduces the
+	// diagonal artefacts of scanning in one direction
+	Serpentine bool
+	// Broken diffuses errors as unsigned bytes that wrap around instead of
+	// accumulating them properly. It isn't a real dither, but the speckled
+	// noise it makes is a nice glitch in its own right.
+	Broken bool
+}
+
+// ErrorDiffusion dithers the image with the kernel, mapping every pixel to the
+// nearest colour in palette
+func ErrorDiffusion(destImage *image.RGBA, kernel Kernel, palette color.Palette) {
+	Diffuser{Kernel: kernel, Palette: palette}.Dither(destImage)
+}
+
+// quantiser returns a function mapping colours to the diffuser's palette
+func (d Diffuser) quantiser() func(r, g, b uint8) (uint8, uint8, uint8) {
+	if len(d.Palette) > 0 {
+		return newMatcher(d.Palette).nearest
+	}
+	return func(r, g, b uint8) (uint8, uint8, uint8) {
+		var newR, newG, newB uint8
+		if r > d.Threshold {
+			newR = 0xff
+		}
+		if g > d.Threshold {
+			newG = 0xff
+		}
+		if b > d.Threshold {
+			newB = 0xff
+		}
+		return newR, newG, newB
+	}
+}
+
+// Dither dithers the image in place
+func (d Diffuser) Dither(destImage *image.RGBA) {
+	if d.Broken {
+		d.ditherBroken(destImage)
+		return
+	}
+
+	bounds := destImage.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	quantise := d.quantiser()
+
+	// Accumulate errors in a signed, higher precision buffer so they can
+	// push pixels below 0 or above 255 without wrapping
+	work := make([]float64, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i, w := destImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y), 3*(y*width+x)
+			work[w] = float64(destImage.Pix[i])
+			work[w+1] = float64(destImage.Pix[i+1])
+			work[w+2] = float64(destImage.Pix[i+2])
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		reverse := d.Serpentine && y%2 == 1
+		for n := 0; n < width; n++ {
+			x := n
+			if reverse {
+				x = width - 1 - n
+			}
+			w := 3 * (y*width + x)
+
+			oldR := clamp(work[w])
+			oldG := clamp(work[w+1])
+			oldB := clamp(work[w+2])
+
+			newR, newG, newB := quantise(uint8(oldR+.5), uint8(oldG+.5), uint8(oldB+.5))
+
+			i := destImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			destImage.Pix[i] = newR
+			destImage.Pix[i+1] = newG
+			destImage.Pix[i+2] = newB
+
+			errR := oldR - float64(newR)
+			errG := oldG - float64(newG)
+			errB := oldB - float64(newB)
+
+			for _, s := range d.Kernel {
+				dx := s.DX
+				if reverse {
+					dx = -dx
+				}
+				nx, ny := x+dx, y+s.DY
+				if nx < 0 || nx >= width || ny >= height {
+					continue
+				}
+				adj := 3 * (ny*width + nx)
+				work[adj] += errR * s.Weight
+				work[adj+1] += errG * s.Weight
+				work[adj+2] += errB * s.Weight
+			}
+		}
+	}
+}
+
+// clamp limits a channel value to 0-255
+func clamp(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// ditherBroken is the original diffusion, which keeps errors as bytes that
+// wrap around when negative and overflow when added to the neighbours
+func (d Diffuser) ditherBroken(destImage *image.RGBA) {
+	bounds := destImage.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	quantise := d.quantiser()
+
+	for y := 0; y < height; y++ {
+		reverse := d.Serpentine && y%2 == 1
+		for n := 0; n < width; n++ {
+			x := n
+			if reverse {
+				x = width - 1 - n
+			}
+			i := destImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+
+			oldR := destImage.Pix[i]
+			oldG := destImage.Pix[i+1]
+			oldB := destImage.Pix[i+2]
+
+			newR, newG, newB := quantise(oldR, oldG, oldB)
+
+			destImage.Pix[i] = newR
+			destImage.Pix[i+1] = newG
+			destImage.Pix[i+2] = newB
+
+			errR := oldR - newR
+			errG := oldG - newG
+			errB := oldB - newB
+
+			for _, s := range d.Kernel {
+				dx := s.DX
+				if reverse {
+					dx = -dx
+				}
+				nx, ny := x+dx, y+s.DY
+				if nx < 0 || nx >= width || ny >= height {
+					continue
+				}
+				adjustPixelError(destImage.Pix, destImage.PixOffset(bounds.Min.X+nx, bounds.Min.Y+ny), errR, errG, errB, s.Weight)
+			}
+		}
+	}
+}
+
+func adjustPixelError(data []uint8, i int, r, g, b uint8, multiplier float64) {
+	if i >= len(data) {
+		return
+	}
+	data[i] = data[i] + uint8(multiplier*float64(r))
+	data[i+1] = data[i+1] + uint8(multiplier*float64(g))
+	data[i+2] = data[i+2] + uint8(multiplier*float64(b))
+}
@@ -0,0 +1,77 @@
+package dither
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKernelWeights(t *testing.T) {
+	tests := []struct {
+		name string
+		// Sum of the weights over the divisor. Atkinson only spreads 6/8
+		// of the error on purpose.
+		sum float64
+	}{
+		{"floydsteinberg", 1},
+		{"atkinsons", 6.0 / 8},
+		{"jarvisjudiceninke", 1},
+		{"stucki", 1},
+		{"burkes", 1},
+		{"sierra", 1},
+		{"tworowsierra", 1},
+		{"sierralite", 1},
+		{"stevensonarce", 1},
+	}
+	if len(tests) != len(Kernels) {
+		t.Errorf("testing %d kernels, but there are %d", len(tests), len(Kernels))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kernel, ok := Kernels[tt.name]
+			if !ok {
+				t.Fatalf("no kernel %q", tt.name)
+			}
+			var sum float64
+			for _, s := range kernel {
+				if s.DY < 0 || (s.DY == 0 && s.DX <= 0) {
+					t.Errorf("tap %d,%d isn't after the current pixel", s.DX, s.DY)
+				}
+				sum += s.Weight
+			}
+			if math.Abs(sum-tt.sum) > 1e-9 {
+				t.Errorf("weights sum to %v, want %v", sum, tt.sum)
+			}
+		})
+	}
+}
+
+func TestBrokenAtkinsonKernel(t *testing.T) {
+	var sum float64
+	for _, s := range BrokenAtkinsonKernel {
+		sum += s.Weight
+	}
+	if math.Abs(sum-6.0/8) > 1e-9 {
+		t.Errorf("weights sum to %v, want 0.75", sum)
+	}
+}
+
+func TestNewKernel(t *testing.T) {
+	kernel := NewKernel([][]float64{
+		{9, 0, 7},
+		{3, 5, 1},
+	}, 16, 1)
+	want := Kernel{
+		{DX: 1, DY: 0, Weight: 7.0 / 16},
+		{DX: -1, DY: 1, Weight: 3.0 / 16},
+		{DX: 0, DY: 1, Weight: 5.0 / 16},
+		{DX: 1, DY: 1, Weight: 1.0 / 16},
+	}
+	if len(kernel) != len(want) {
+		t.Fatalf("got %v, want %v", kernel, want)
+	}
+	for i := range want {
+		if kernel[i] != want[i] {
+			t.Errorf("tap %d is %v, want %v", i, kernel[i], want[i])
+		}
+	}
+}
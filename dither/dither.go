@@ -2,76 +2,198 @@ package dither
 
 import (
 	"image"
+	"image/color"
 	"math"
 )
 
+// Pixelate is a blocky "8bit" dither that sets each block of pixels to one
+// colour. The colour is the average of the block, or the pixel at its centre,
+// which is then mapped to the nearest colour in Palette, quantised to Levels
+// per channel or thresholded per channel.
+type Pixelate struct {
+	// Size is the width and height of the blocks, 4 if not set
+	Size int
+	// Width and Height override Size, for blocks that aren't square
+	Width, Height int
+	// Sample takes the colour of the pixel at the centre of each block rather
+	// than averaging the whole block
+	Sample bool
+	// Threshold is the per-channel threshold used when Palette is empty and
+	// Levels is less than 2
+	Threshold int
+	// Thresholds are separate red, green and blue thresholds, used instead of
+	// Threshold if set
+	Thresholds []int
+	// Levels quantises each channel to this many evenly spaced levels, rather
+	// than thresholding it to 0 or 255
+	Levels int
+	// Palette is the set of colours to map blocks to
+	Palette color.Palette
+}
+
 // EightBit does an 8bit dither of the given image
 func EightBit(destImage *image.RGBA, threshold int) {
+	Pixelate{Size: 4, Threshold: threshold}.Dither(destImage)
+}
+
+// quantiser returns a function mapping block colours to their final colour
+func (p Pixelate) quantiser() func(r, g, b uint8) (uint8, uint8, uint8) {
+	if len(p.Palette) > 0 {
+		return newMatcher(p.Palette).nearest
+	}
+	if p.Levels >= 2 {
+		step := 255 / float64(p.Levels-1)
+		level := func(v uint8) uint8 {
+			return uint8(math.Floor(float64(v)/step+.5)*step + .5)
+		}
+		return func(r, g, b uint8) (uint8, uint8, uint8) {
+			return level(r), level(g), level(b)
+		}
+	}
+
+	thresholds := [3]int{p.Threshold, p.Threshold, p.Threshold}
+	copy(thresholds[:], p.Thresholds)
+	return func(r, g, b uint8) (uint8, uint8, uint8) {
+		var newR, newG, newB uint8
+		if int(r) > thresholds[0] {
+			newR = 0xff
+		}
+		if int(g) > thresholds[1] {
+			newG = 0xff
+		}
+		if int(b) > thresholds[2] {
+			newB = 0xff
+		}
+		return newR, newG, newB
+	}
+}
+
+// Dither pixelates the image in place
+func (p Pixelate) Dither(destImage *image.RGBA) {
 	bounds := destImage.Bounds()
-	width := bounds.Max.X
-	height := bounds.Max.Y
 
-	size := 4
-	sizeSq := uint16(size * size)
-	for y := 0; y < height; y += size {
-		for x := 0; x < width; x += size {
-			var sumR, sumG, sumB uint16
-			for sY := 0; sY < size; sY++ {
-				for sX := 0; sX < size; sX++ {
-					i := 4 * (width*(y+sY) + (x + sX))
-					if i >= len(destImage.Pix) {
-						continue
+	blockWidth, blockHeight := p.Width, p.Height
+	if blockWidth <= 0 {
+		blockWidth = p.Size
+	}
+	if blockHeight <= 0 {
+		blockHeight = p.Size
+	}
+	if blockWidth <= 0 {
+		blockWidth = 4
+	}
+	if blockHeight <= 0 {
+		blockHeight = 4
+	}
+	quantise := p.quantiser()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += blockHeight {
+		for x := bounds.Min.X; x < bounds.Max.X; x += blockWidth {
+			block := image.Rect(x, y, x+blockWidth, y+blockHeight).Intersect(bounds)
+
+			var r, g, b uint8
+			if p.Sample {
+				i := destImage.PixOffset((block.Min.X+block.Max.X)/2, (block.Min.Y+block.Max.Y)/2)
+				r, g, b = destImage.Pix[i], destImage.Pix[i+1], destImage.Pix[i+2]
+			} else {
+				var sumR, sumG, sumB int
+				for bY := block.Min.Y; bY < block.Max.Y; bY++ {
+					for bX := block.Min.X; bX < block.Max.X; bX++ {
+						i := destImage.PixOffset(bX, bY)
+						sumR += int(destImage.Pix[i])
+						sumG += int(destImage.Pix[i+1])
+						sumB += int(destImage.Pix[i+2])
 					}
-					sumR += uint16(destImage.Pix[i])
-					sumG += uint16(destImage.Pix[i+1])
-					sumB += uint16(destImage.Pix[i+2])
 				}
+				count := block.Dx() * block.Dy()
+				r, g, b = uint8(sumR/count), uint8(sumG/count), uint8(sumB/count)
 			}
+			r, g, b = quantise(r, g, b)
 
-			var avgR, avgG, avgB uint8
-			if sumR/sizeSq > uint16(threshold) {
-				avgR = 0xff
-			}
-			if sumG/sizeSq > uint16(threshold) {
-				avgG = 0xff
-			}
-			if sumB/sizeSq > uint16(threshold) {
-				avgB = 0xff
-			}
-
-			for sY := 0; sY < size; sY++ {
-				for sX := 0; sX < size; sX++ {
-					i := 4 * (width*(y+sY) + (x + sX))
-					if i >= len(destImage.Pix) {
-						continue
-					}
-					destImage.Pix[i] = avgR
-					destImage.Pix[i+1] = avgG
-					destImage.Pix[i+2] = avgB
+			for bY := block.Min.Y; bY < block.Max.Y; bY++ {
+				for bX := block.Min.X; bX < block.Max.X; bX++ {
+					i := destImage.PixOffset(bX, bY)
+					destImage.Pix[i] = r
+					destImage.Pix[i+1] = g
+					destImage.Pix[i+2] = b
 				}
 			}
 		}
 	}
 }
 
-// Bayer does a Bayer dither of the given image
+// BayerMatrix is the classic 4x4 Bayer threshold map, scaled to 0-1
+var BayerMatrix = BayerMatrixN(4)
+
+// Ordered is an ordered dither, comparing pixels against a threshold matrix
+// tiled over the image. The matrix can be any of the generated ones in
+// Matrices or a custom one.
+type Ordered struct {
+	// Matrix holds thresholds between 0 and 1. BayerMatrix is used if nil.
+	Matrix [][]float64
+	// PerChannel compares each channel against the matrix separately, giving
+	// eight colours rather than black and white. It is ignored with a Palette.
+	PerChannel bool
+	// Palette is the set of colours to map pixels to after the matrix has
+	// nudged them. If it is empty the output is black and white.
+	Palette color.Palette
+	// Spread is how far the matrix can nudge each channel when mapping to
+	// Palette. If 0, it is based on the size of the palette.
+	Spread float64
+}
+
+// Bayer does a black and white 4x4 Bayer dither of the given image
 func Bayer(destImage *image.RGBA) {
+	Ordered{Matrix: BayerMatrix}.Dither(destImage)
+}
+
+// Dither dithers the image in place
+func (o Ordered) Dither(destImage *image.RGBA) {
 	bounds := destImage.Bounds()
-	width := bounds.Max.X
-	height := bounds.Max.Y
-	thresholdMap := [][]float64{
-		{1, 9, 3, 11},
-		{13, 5, 15, 7},
-		{4, 12, 2, 10},
-		{16, 8, 14, 6},
+
+	matrix := o.Matrix
+	if len(matrix) == 0 {
+		matrix = BayerMatrix
+	}
+	var palette *matcher
+	spread := o.Spread
+	if len(o.Palette) > 0 {
+		palette = newMatcher(o.Palette)
+		if spread == 0 {
+			spread = 255 / math.Max(1, math.Cbrt(float64(len(o.Palette)))-1)
+		}
 	}
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			i := 4 * (y*width + x)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := matrix[(y-bounds.Min.Y)%len(matrix)]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := destImage.PixOffset(x, y)
+			threshold := row[(x-bounds.Min.X)%len(row)]
+
+			if palette != nil {
+				nudge := (threshold - 0.5) * spread
+				destImage.Pix[i], destImage.Pix[i+1], destImage.Pix[i+2] = palette.nearest(
+					nudgeChannel(destImage.Pix[i], nudge),
+					nudgeChannel(destImage.Pix[i+1], nudge),
+					nudgeChannel(destImage.Pix[i+2], nudge),
+				)
+				continue
+			}
+
+			if o.PerChannel {
+				for c := i; c < i+3; c++ {
+					if float64(destImage.Pix[c])/255 > threshold {
+						destImage.Pix[c] = 0xff
+					} else {
+						destImage.Pix[c] = 0
+					}
+				}
+				continue
+			}
+
 			gray := .3*float64(destImage.Pix[i]) + .59*float64(destImage.Pix[i+1]) + .11*float64(destImage.Pix[i+2])
-			scaled := (gray * 17) / 255
 			var val uint8
-			if scaled > thresholdMap[x%4][y%4] {
+			if gray/255 > threshold {
 				val = 0xff
 			}
 			destImage.Pix[i] = val
@@ -81,228 +203,112 @@ func Bayer(destImage *image.RGBA) {
 	}
 }
 
+// nudgeChannel adds an offset to a channel value, clamped to 0-255
+func nudgeChannel(v uint8, nudge float64) uint8 {
+	return uint8(clamp(float64(v)+nudge) + .5)
+}
+
+// Halftoner is a simple halftone on a grid of 3x3 cells. Each cell is filled
+// with a dot of its average colour, growing larger the darker the cell is.
+// Screen is a more realistic print style halftone.
+type Halftoner struct {
+	// Threshold is the per-channel threshold for the dot colour, used when
+	// Palette is empty
+	Threshold uint16
+	// Palette is the set of colours to map dots and paper to
+	Palette color.Palette
+}
+
+// halftoneOrder is the order the cells of the 3x3 grid are inked in as the
+// dot grows
+var halftoneOrder = [9]int{4, 5, 1, 6, 3, 8, 2, 0, 7}
+
 // Halftone does a halftone dither of the given image
 func Halftone(destImage *image.RGBA, threshold uint16) {
+	Halftoner{Threshold: threshold}.Dither(destImage)
+}
+
+// Dither halftones the image in place
+func (h Halftoner) Dither(destImage *image.RGBA) {
 	bounds := destImage.Bounds()
-	width := bounds.Max.X
-	height := bounds.Max.Y
-	for y := 0; y <= height-2; y += 3 {
-		for x := 0; x <= width-2; x += 3 {
+
+	paperR, paperG, paperB := uint8(0xff), uint8(0xff), uint8(0xff)
+	var palette *matcher
+	if len(h.Palette) > 0 {
+		palette = newMatcher(h.Palette)
+		paperR, paperG, paperB = palette.nearest(0xff, 0xff, 0xff)
+	}
+
+	for y := bounds.Min.Y; y+3 <= bounds.Max.Y; y += 3 {
+		for x := bounds.Min.X; x+3 <= bounds.Max.X; x += 3 {
 			var sumR, sumG, sumB uint16
-			var indexed []int
+			var indexed [9]int
 			count := 0
 			for sY := 0; sY < 3; sY++ {
 				for sX := 0; sX < 3; sX++ {
-					i := 4 * (width*(y+sY) + (x + sX))
+					i := destImage.PixOffset(x+sX, y+sY)
 					sumR += uint16(destImage.Pix[i])
 					sumG += uint16(destImage.Pix[i+1])
 					sumB += uint16(destImage.Pix[i+2])
-					destImage.Pix[i] = 0xff
-					destImage.Pix[i+1] = 0xff
-					destImage.Pix[i+2] = 0xff
-					indexed = append(indexed, i)
+					destImage.Pix[i] = paperR
+					destImage.Pix[i+1] = paperG
+					destImage.Pix[i+2] = paperB
+					indexed[count] = i
 					count++
 				}
 			}
 
 			var avgR, avgG, avgB uint8
-			if (sumR / 9) > threshold {
-				avgR = 0xff
-			}
-			if (sumG / 9) > threshold {
-				avgG = 0xff
-			}
-			if (sumB / 9) > threshold {
-				avgB = 0xff
-			}
-			avgLum := float64(avgR+avgG+avgB) / 3
-			scaled := math.Floor(((avgLum * 9) / 255) + .5)
-			if scaled < 9 {
-				destImage.Pix[indexed[4]] = avgR
-				destImage.Pix[indexed[4]+1] = avgG
-				destImage.Pix[indexed[4]+2] = avgB
-			}
-			if scaled < 8 {
-				destImage.Pix[indexed[5]] = avgR
-				destImage.Pix[indexed[5]+1] = avgG
-				destImage.Pix[indexed[5]+2] = avgB
-			}
-			if scaled < 7 {
-				destImage.Pix[indexed[1]] = avgR
-				destImage.Pix[indexed[1]+1] = avgG
-				destImage.Pix[indexed[1]+2] = avgB
-			}
-			if scaled < 6 {
-				destImage.Pix[indexed[6]] = avgR
-				destImage.Pix[indexed[6]+1] = avgG
-				destImage.Pix[indexed[6]+2] = avgB
-			}
-			if scaled < 5 {
-				destImage.Pix[indexed[3]] = avgR
-				destImage.Pix[indexed[3]+1] = avgG
-				destImage.Pix[indexed[3]+2] = avgB
-			}
-			if scaled < 4 {
-				destImage.Pix[indexed[8]] = avgR
-				destImage.Pix[indexed[8]+1] = avgG
-				destImage.Pix[indexed[8]+2] = avgB
-			}
-			if scaled < 3 {
-				destImage.Pix[indexed[2]] = avgR
-				destImage.Pix[indexed[2]+1] = avgG
-				destImage.Pix[indexed[2]+2] = avgB
-			}
-			if scaled < 2 {
-				destImage.Pix[indexed[0]] = avgR
-				destImage.Pix[indexed[0]+1] = avgG
-				destImage.Pix[indexed[0]+2] = avgB
+			var avgLum float64
+			if palette != nil {
+				avgR, avgG, avgB = palette.nearest(uint8(sumR/9), uint8(sumG/9), uint8(sumB/9))
+				avgLum = float64(sumR+sumG+sumB) / 27
+			} else {
+				if (sumR / 9) > h.Threshold {
+					avgR = 0xff
+				}
+				if (sumG / 9) > h.Threshold {
+					avgG = 0xff
+				}
+				if (sumB / 9) > h.Threshold {
+					avgB = 0xff
+				}
+				// The sum can wrap around, which is part of the look
+				avgLum = float64(avgR+avgG+avgB) / 3
 			}
 
-			if scaled < 1 {
-				destImage.Pix[indexed[7]] = avgR
-				destImage.Pix[indexed[7]+1] = avgG
-				destImage.Pix[indexed[7]+2] = avgB
+			scaled := int(math.Floor(((avgLum * 9) / 255) + .5))
+			for n, cell := range halftoneOrder {
+				if scaled >= 9-n {
+					break
+				}
+				destImage.Pix[indexed[cell]] = avgR
+				destImage.Pix[indexed[cell]+1] = avgG
+				destImage.Pix[indexed[cell]+2] = avgB
 			}
 		}
 	}
 }
 
-func adjustPixelError(data []uint8, i int, r, g, b uint8, multiplier float64) {
-	if i >= len(data) {
-		return
-	}
-	data[i] = data[i] + uint8(multiplier*float64(r))
-	data[i+1] = data[i+1] + uint8(multiplier*float64(g))
-	data[i+2] = data[i+2] + uint8(multiplier*float64(b))
-}
-
 // Atkinsons does an Atkinsons dither of the given image
 func Atkinsons(destImage *image.RGBA, threshold uint8) {
-	bounds := destImage.Bounds()
-	width := bounds.Max.X
-	height := bounds.Max.Y
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			i := 4 * (y*width + x)
-
-			oldR := destImage.Pix[i]
-			oldG := destImage.Pix[i+1]
-			oldB := destImage.Pix[i+2]
-
-			var newR, newG, newB uint8
-			if oldR > threshold {
-				newR = 0xff
-			}
-			if oldG > threshold {
-				newG = 0xff
-			}
-			if oldB > threshold {
-				newB = 0xff
-			}
-
-			destImage.Pix[i] = newR
-			destImage.Pix[i+1] = newG
-			destImage.Pix[i+2] = newB
-
-			errR := oldR - newR
-			errG := oldG - newG
-			errB := oldB - newB
-
-			// Redistribute the pixel's error like this:
-			//       *  1/8 1/8
-			//  1/8 1/8 1/8
-			//      1/8
-			// The ones to the right...
-			if x < width-1 {
-				adjI := i + 4
-				adjustPixelError(destImage.Pix, adjI, errR, errG, errB, 1.0/8.0)
-				// The pixel that's down and to the right
-				if y < height-1 {
-					adjI = adjI + (width * 4) + 4
-					adjustPixelError(destImage.Pix, adjI, errR, errG, errB, 1.0/8.0)
-				}
-				// The pixel two over
-				if x < width-2 {
-					adjI = i + 8
-					adjustPixelError(destImage.Pix, adjI, errR, errG, errB, 1.0/8.0)
-				}
-			}
-			if y < height-1 {
-				// The one right below
-				adjI := i + (width * 4)
-				adjustPixelError(destImage.Pix, adjI, errR, errG, errB, 1.0/8.0)
-				if x > 0 {
-					// The one to the left
-					adjI = adjI - 4
-					adjustPixelError(destImage.Pix, adjI, errR, errG, errB, 1.0/8.0)
-				}
-				if y < height-2 {
-					// The one two down
-					adjI = i + (2 * width * 4)
-					adjustPixelError(destImage.Pix, adjI, errR, errG, errB, 1.0/8.0)
-				}
-			}
-		}
-	}
+	Diffuser{Kernel: AtkinsonKernel, Threshold: threshold}.Dither(destImage)
 }
 
 // FloydSteinberg does a Floyd-Steinberg dither of the given image
 func FloydSteinberg(destImage *image.RGBA, threshold uint8) {
-	bounds := destImage.Bounds()
-	width := bounds.Max.X
-	height := bounds.Max.Y
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			i := 4 * (y*width + x)
-
-			oldR := destImage.Pix[i]
-			oldG := destImage.Pix[i+1]
-			oldB := destImage.Pix[i+2]
-
-			var newR, newG, newB uint8
-			if oldR > threshold {
-				newR = 0xff
-			}
-			if oldG > threshold {
-				newG = 0xff
-			}
-			if oldB > threshold {
-				newB = 0xff
-			}
+	Diffuser{Kernel: FloydSteinbergKernel, Threshold: threshold}.Dither(destImage)
+}
 
-			destImage.Pix[i] = newR
-			destImage.Pix[i+1] = newG
-			destImage.Pix[i+2] = newB
-
-			errR := oldR - newR
-			errG := oldG - newG
-			errB := oldB - newB
-
-			// Redistribute the pixel's error like this:
-			//   * 7
-			// 3 5 1
-			// The ones to the right...
-			if x < width-1 {
-				rightI := i + 4
-				adjustPixelError(destImage.Pix, rightI, errR, errG, errB, 7.0/16.0)
-				// The pixel that's down and to the right
-				if y < height-1 {
-					nextRightI := rightI + (width * 4)
-					adjustPixelError(destImage.Pix, nextRightI, errR, errG, errB, 1.0/16.0)
-				}
-			}
+// BrokenAtkinsons does an Atkinsons dither with wrapping byte errors, for a
+// noisy speckled glitch rather than a true dither. It uses
+// BrokenAtkinsonKernel, so it looks like the original Atkinsons dither.
+func BrokenAtkinsons(destImage *image.RGBA, threshold uint8) {
+	Diffuser{Kernel: BrokenAtkinsonKernel, Threshold: threshold, Broken: true}.Dither(destImage)
+}
 
-			if y < height-1 {
-				// The one right below
-				downI := i + (width * 4)
-				adjustPixelError(destImage.Pix, downI, errR, errG, errB, 5.0/16.0)
-				if x > 0 {
-					// The one down and to the left...
-					leftI := downI - 4
-					adjustPixelError(destImage.Pix, leftI, errR, errG, errB, 3.0/16.0)
-				}
-			}
-		}
-	}
+// BrokenFloydSteinberg does a Floyd-Steinberg dither with wrapping byte
+// errors, for a noisy speckled glitch rather than a true dither
+func BrokenFloydSteinberg(destImage *image.RGBA, threshold uint8) {
+	Diffuser{Kernel: FloydSteinbergKernel, Threshold: threshold, Broken: true}.Dither(destImage)
 }
@@ -0,0 +1,88 @@
+package dither
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// testImage returns an image with a colourful pattern, so every ditherer has
+// some work to do
+func testImage(bounds image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i] = uint8(x * 37)
+			img.Pix[i+1] = uint8(y * 23)
+			img.Pix[i+2] = uint8((x + y) * 11)
+			img.Pix[i+3] = 0xff
+		}
+	}
+	return img
+}
+
+var ditherers = []struct {
+	name   string
+	dither func(*image.RGBA)
+}{
+	{"EightBit", func(img *image.RGBA) { EightBit(img, 128) }},
+	{"Pixelate", Pixelate{Size: 3}.Dither},
+	{"PixelateSample", Pixelate{Width: 5, Height: 2, Sample: true}.Dither},
+	{"PixelateLevels", Pixelate{Size: 4, Levels: 3}.Dither},
+	{"PixelateThresholds", Pixelate{Size: 2, Thresholds: []int{64, 128, 192}}.Dither},
+	{"PixelatePalette", Pixelate{Size: 2, Palette: Palettes["gameboy"]}.Dither},
+	{"Bayer", Bayer},
+	{"OrderedPerChannel", Ordered{Matrix: BayerMatrixN(8), PerChannel: true}.Dither},
+	{"OrderedPalette", Ordered{Matrix: BlueNoiseMatrix(8), Palette: Palettes["cga"]}.Dither},
+	{"OrderedClusterDot", Ordered{Matrix: ClusterDotMatrix(4)}.Dither},
+	{"Halftone", func(img *image.RGBA) { Halftone(img, 128) }},
+	{"HalftonerPalette", Halftoner{Palette: Palettes["pico8"]}.Dither},
+	{"Atkinsons", func(img *image.RGBA) { Atkinsons(img, 128) }},
+	{"FloydSteinberg", func(img *image.RGBA) { FloydSteinberg(img, 128) }},
+	{"BrokenAtkinsons", func(img *image.RGBA) { BrokenAtkinsons(img, 128) }},
+	{"BrokenFloydSteinberg", func(img *image.RGBA) { BrokenFloydSteinberg(img, 128) }},
+	{"ErrorDiffusion", func(img *image.RGBA) { ErrorDiffusion(img, StuckiKernel, Palettes["ega"]) }},
+	{"DiffuserSerpentine", Diffuser{Kernel: JarvisJudiceNinkeKernel, Threshold: 100, Serpentine: true}.Dither},
+	{"DiffuserBrokenSerpentine", Diffuser{Kernel: StevensonArceKernel, Threshold: 100, Serpentine: true, Broken: true}.Dither},
+	{"ScreenCMYK", Screen{Size: 4}.Dither},
+	{"ScreenRGB", Screen{Size: 3, Shape: EllipticalDot, Separation: SeparateRGB}.Dither},
+	{"ScreenGray", Screen{Size: 5, Shape: LineDot, Separation: SeparateGray, Palette: Palettes["gameboy"]}.Dither},
+}
+
+func TestDitherSubImage(t *testing.T) {
+	crop := image.Rect(7, 5, 23, 19)
+	for _, tt := range ditherers {
+		t.Run(tt.name, func(t *testing.T) {
+			whole := testImage(image.Rect(0, 0, 32, 24))
+			before := append([]uint8(nil), whole.Pix...)
+			tt.dither(whole.SubImage(crop).(*image.RGBA))
+
+			// Nothing outside the crop may change
+			for y := whole.Rect.Min.Y; y < whole.Rect.Max.Y; y++ {
+				for x := whole.Rect.Min.X; x < whole.Rect.Max.X; x++ {
+					if image.Pt(x, y).In(crop) {
+						continue
+					}
+					i := whole.PixOffset(x, y)
+					if !bytes.Equal(whole.Pix[i:i+4], before[i:i+4]) {
+						t.Fatalf("pixel %d,%d outside the sub-image changed", x, y)
+					}
+				}
+			}
+
+			// The crop must match dithering a standalone image with the
+			// same bounds
+			alone := testImage(crop)
+			tt.dither(alone)
+			for y := crop.Min.Y; y < crop.Max.Y; y++ {
+				for x := crop.Min.X; x < crop.Max.X; x++ {
+					a, b := whole.PixOffset(x, y), alone.PixOffset(x, y)
+					if !bytes.Equal(whole.Pix[a:a+4], alone.Pix[b:b+4]) {
+						t.Fatalf("pixel %d,%d is %v in the sub-image but %v alone", x, y, whole.Pix[a:a+4], alone.Pix[b:b+4])
+					}
+				}
+			}
+		})
+	}
+}
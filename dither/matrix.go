@@ -0,0 +1,207 @@
+package dither
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Matrices maps the names of the threshold matrix generators to the
+// generators. Each takes the width and height of the matrix, using its own
+// default if that is 0.
+var Matrices = map[string]func(size int) [][]float64{
+	"bayer":      BayerMatrixN,
+	"clusterdot": ClusterDotMatrix,
+	"bluenoise":  BlueNoiseMatrix,
+}
+
+// BayerMatrixN builds a Bayer threshold matrix, recursively doubling the 2x2
+// matrix until it is at least size wide. Sizes that aren't a power of two are
+// rounded up, and the default is 4.
+func BayerMatrixN(size int) [][]float64 {
+	if size <= 0 {
+		size = 4
+	}
+
+	ranks := [][]int{{0}}
+	for len(ranks) < size {
+		n := len(ranks)
+		next := make([][]int, n*2)
+		for y := range next {
+			next[y] = make([]int, n*2)
+		}
+		for y, row := range ranks {
+			for x, r := range row {
+				next[y][x] = 4 * r
+				next[y][x+n] = 4*r + 2
+				next[y+n][x] = 4*r + 3
+				next[y+n][x+n] = 4*r + 1
+			}
+		}
+		ranks = next
+	}
+	return normaliseRanks(ranks)
+}
+
+// ClusterDotMatrix builds a clustered dot threshold matrix, where dots grow
+// outwards from the centre of each size by size cell like a print halftone.
+// The default size is 8.
+func ClusterDotMatrix(size int) [][]float64 {
+	if size <= 0 {
+		size = 8
+	}
+
+	type cell struct {
+		x, y        int
+		dist, angle float64
+	}
+	cells := make([]cell, 0, size*size)
+	centre := float64(size) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := float64(x)+.5-centre, float64(y)+.5-centre
+			cells = append(cells, cell{x, y, math.Hypot(dx, dy), math.Atan2(dy, dx)})
+		}
+	}
+	// The centre gets the highest threshold so it is the first to go dark,
+	// with ties broken by angle so the dot grows in a spiral
+	sort.SliceStable(cells, func(i, j int) bool {
+		if cells[i].dist != cells[j].dist {
+			return cells[i].dist > cells[j].dist
+		}
+		return cells[i].angle < cells[j].angle
+	})
+
+	ranks := make([][]int, size)
+	for y := range ranks {
+		ranks[y] = make([]int, size)
+	}
+	for r, c := range cells {
+		ranks[c.y][c.x] = r
+	}
+	return normaliseRanks(ranks)
+}
+
+// blueNoiseSigma is the spread of the Gaussian filter used to find clusters
+// and voids when building blue noise
+const blueNoiseSigma = 1.5
+
+// BlueNoiseMatrix builds a blue noise threshold matrix with Ulichney's
+// void-and-cluster method, which gives an even, unpatterned dither. The
+// matrix tiles seamlessly. It is always the same for a given size, which
+// defaults to 16. Building it takes time proportional to the fourth power of
+// size, so sizes above 64 are slow.
+func BlueNoiseMatrix(size int) [][]float64 {
+	if size <= 0 {
+		size = 16
+	}
+	n := size * size
+
+	// Gaussian weights for every offset, wrapping around the edges
+	weights := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			wx, wy := math.Min(float64(dx), float64(size-dx)), math.Min(float64(dy), float64(size-dy))
+			weights[dy*size+dx] = math.Exp(-(wx*wx + wy*wy) / (2 * blueNoiseSigma * blueNoiseSigma))
+		}
+	}
+
+	pattern := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(p int, on bool) {
+		pattern[p] = on
+		sign := 1.0
+		if !on {
+			sign = -1
+		}
+		px, py := p%size, p/size
+		for q := range energy {
+			dx, dy := (q%size-px+size)%size, (q/size-py+size)%size
+			energy[q] += sign * weights[dy*size+dx]
+		}
+	}
+	// tightest finds the set point with the most set points around it
+	tightest := func() int {
+		best := -1
+		for p, on := range pattern {
+			if on && (best < 0 || energy[p] > energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+	// largestVoid finds the unset point furthest from any set points
+	largestVoid := func() int {
+		best := -1
+		for p, on := range pattern {
+			if !on && (best < 0 || energy[p] < energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+
+	// Scatter a tenth of the points at random, then move points from the
+	// tightest cluster to the largest void until they are evenly spread
+	rng := rand.New(rand.NewSource(1))
+	initial := n / 10
+	if initial < 1 {
+		initial = 1
+	}
+	for placed := 0; placed < initial; {
+		if p := rng.Intn(n); !pattern[p] {
+			toggle(p, true)
+			placed++
+		}
+	}
+	for i := 0; i < n; i++ {
+		cluster := tightest()
+		toggle(cluster, false)
+		void := largestVoid()
+		toggle(void, true)
+		if void == cluster {
+			break
+		}
+	}
+	prototype := append([]bool(nil), pattern...)
+	prototypeEnergy := append([]float64(nil), energy...)
+
+	// Rank the initial points by removing the tightest clusters first, then
+	// rank the rest by filling the largest voids
+	rank := make([]int, n)
+	for r := initial - 1; r >= 0; r-- {
+		cluster := tightest()
+		toggle(cluster, false)
+		rank[cluster] = r
+	}
+	copy(pattern, prototype)
+	copy(energy, prototypeEnergy)
+	for r := initial; r < n; r++ {
+		void := largestVoid()
+		toggle(void, true)
+		rank[void] = r
+	}
+
+	ranks := make([][]int, size)
+	for y := range ranks {
+		ranks[y] = rank[y*size : (y+1)*size]
+	}
+	return normaliseRanks(ranks)
+}
+
+// normaliseRanks turns a matrix of ranks from 0 to n-1 into thresholds
+// evenly spaced between 0 and 1
+func normaliseRanks(ranks [][]int) [][]float64 {
+	n := 0
+	for _, row := range ranks {
+		n += len(row)
+	}
+	matrix := make([][]float64, len(ranks))
+	for y, row := range ranks {
+		matrix[y] = make([]float64, len(row))
+		for x, r := range row {
+			matrix[y][x] = float64(r+1) / float64(n+1)
+		}
+	}
+	return matrix
+}
@@ -0,0 +1,72 @@
+package dither
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBayerMatrixN(t *testing.T) {
+	tests := []struct {
+		size  int
+		ranks [][]int
+	}{
+		{2, [][]int{
+			{0, 2},
+			{3, 1},
+		}},
+		{4, [][]int{
+			{0, 8, 2, 10},
+			{12, 4, 14, 6},
+			{3, 11, 1, 9},
+			{15, 7, 13, 5},
+		}},
+		// Rounded up to the next power of two
+		{3, [][]int{
+			{0, 8, 2, 10},
+			{12, 4, 14, 6},
+			{3, 11, 1, 9},
+			{15, 7, 13, 5},
+		}},
+	}
+	for _, tt := range tests {
+		matrix := BayerMatrixN(tt.size)
+		n := float64(len(tt.ranks) * len(tt.ranks))
+		if len(matrix) != len(tt.ranks) {
+			t.Fatalf("size %d: got %d rows, want %d", tt.size, len(matrix), len(tt.ranks))
+		}
+		for y, row := range tt.ranks {
+			if len(matrix[y]) != len(row) {
+				t.Fatalf("size %d: row %d has %d values, want %d", tt.size, y, len(matrix[y]), len(row))
+			}
+			for x, rank := range row {
+				want := float64(rank+1) / (n + 1)
+				if math.Abs(matrix[y][x]-want) > 1e-9 {
+					t.Errorf("size %d: value at %d,%d is %v, want %v", tt.size, x, y, matrix[y][x], want)
+				}
+			}
+		}
+	}
+}
+
+func TestMatricesAreRanked(t *testing.T) {
+	// Every generated matrix holds each threshold once, evenly spaced
+	for name, generate := range Matrices {
+		for _, size := range []int{0, 4, 8} {
+			matrix := generate(size)
+			n := 0
+			for _, row := range matrix {
+				n += len(row)
+			}
+			seen := make(map[int]bool)
+			for _, row := range matrix {
+				for _, v := range row {
+					rank := int(math.Round(v*float64(n+1))) - 1
+					if rank < 0 || rank >= n || seen[rank] {
+						t.Fatalf("%s size %d: threshold %v is out of place", name, size, v)
+					}
+					seen[rank] = true
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package dither
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrEmptyPalette is returned when a palette file has no colours in it
+var ErrEmptyPalette = errors.New("dither: palette has no colours")
+
+// hexPalette builds a palette from hex colour strings
+func hexPalette(colours ...string) color.Palette {
+	p := make(color.Palette, 0, len(colours))
+	for _, c := range colours {
+		rgb, err := hex.DecodeString(c)
+		if err != nil || len(rgb) != 3 {
+			panic("dither: bad palette colour " + c)
+		}
+		p = append(p, color.RGBA{rgb[0], rgb[1], rgb[2], 0xff})
+	}
+	return p
+}
+
+// Built in retro palettes
+var (
+	// GameBoy is the four shades of green of the original Game Boy
+	GameBoy = hexPalette("0f380f", "306230", "8bac0f", "9bbc0f")
+
+	// CGA is the high intensity black, cyan, magenta and white CGA palette
+	CGA = hexPalette("000000", "55ffff", "ff55ff", "ffffff")
+
+	// EGA is the default 16 colour EGA palette
+	EGA = hexPalette(
+		"000000", "0000aa", "00aa00", "00aaaa", "aa0000", "aa00aa", "aa5500", "aaaaaa",
+		"555555", "5555ff", "55ff55", "55ffff", "ff5555", "ff55ff", "ffff55", "ffffff",
+	)
+
+	// C64 is the Commodore 64 palette as measured by Pepto
+	C64 = hexPalette(
+		"000000", "ffffff", "68372b", "70a4b2", "6f3d86", "588d43", "352879", "b8c76f",
+		"6f4f25", "433900", "9a6759", "444444", "6c6c6c", "9ad284", "6c5eb5", "959595",
+	)
+
+	// PICO8 is the palette of the PICO-8 fantasy console
+	PICO8 = hexPalette(
+		"000000", "1d2b53", "7e2553", "008751", "ab5236", "5f574f", "c2c3c7", "fff1e8",
+		"ff004d", "ffa300", "ffec27", "00e436", "29adff", "83769c", "ff77a8", "ffccaa",
+	)
+
+	// NES is the distinct colours of the NES palette
+	NES = hexPalette(
+		"000000", "fcfcfc", "f8f8f8", "bcbcbc", "7c7c7c", "a4e4fc", "3cbcfc", "0078f8",
+		"0000fc", "b8b8f8", "6888fc", "0058f8", "0000bc", "d8b8f8", "9878f8", "6844fc",
+		"4428bc", "f8b8f8", "f878f8", "d800cc", "940084", "f8a4c0", "f85898", "e40058",
+		"a80020", "f0d0b0", "f87858", "f83800", "a81000", "fce0a8", "fca044", "e45c10",
+		"881400", "f8d878", "f8b800", "ac7c00", "503000", "d8f878", "b8f818", "00b800",
+		"007800", "b8f8b8", "58d854", "00a800", "006800", "b8f8d8", "58f898", "00a844",
+		"005800", "00fcfc", "00e8d8", "008888", "004058", "f8d8f8", "787878",
+	)
+
+	// ZXSpectrum is the ZX Spectrum palette, normal and bright
+	ZXSpectrum = hexPalette(
+		"000000", "0000d7", "d70000", "d700d7", "00d700", "00d7d7", "d7d700", "d7d7d7",
+		"0000ff", "ff0000", "ff00ff", "00ff00", "00ffff", "ffff00", "ffffff",
+	)
+)
+
+// Palettes maps the names of the built in palettes to the palettes
+var Palettes = map[string]color.Palette{
+	"gameboy":    GameBoy,
+	"cga":        CGA,
+	"ega":        EGA,
+	"c64":        C64,
+	"pico8":      PICO8,
+	"nes":        NES,
+	"zxspectrum": ZXSpectrum,
+}
+
+// LoadPalette reads a palette in GIMP (.gpl), Adobe Colour Table (.act) or
+// hex (one RRGGBB colour per line) format, detecting which from the data
+func LoadPalette(r io.Reader) (color.Palette, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var p color.Palette
+	switch {
+	case bytes.HasPrefix(data, []byte("GIMP Palette")):
+		p, err = parseGPL(data)
+	case len(data) == 768 || len(data) == 772:
+		p = parseACT(data)
+	default:
+		p, err = parseHex(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, ErrEmptyPalette
+	}
+	return p, nil
+}
+
+// LoadPaletteFile reads a palette from the named file
+func LoadPaletteFile(name string) (color.Palette, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadPalette(f)
+}
+
+// parseGPL parses a GIMP palette, where each colour is a line of "R G B name"
+func parseGPL(data []byte) (color.Palette, error) {
+	var p color.Palette
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Scan() // Skip the "GIMP Palette" header
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || strings.Contains(line, ":") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("dither: bad GIMP palette line %q", line)
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			v, err := strconv.ParseUint(fields[i], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("dither: bad GIMP palette line %q", line)
+			}
+			rgb[i] = uint8(v)
+		}
+		p = append(p, color.RGBA{rgb[0], rgb[1], rgb[2], 0xff})
+	}
+	return p, scanner.Err()
+}
+
+// parseACT parses an Adobe Colour Table of 256 RGB triplets, optionally
+// followed by the number of colours actually used
+func parseACT(data []byte) color.Palette {
+	count := 256
+	if len(data) == 772 {
+		if n := int(data[768])<<8 | int(data[769]); n > 0 && n <= 256 {
+			count = n
+		}
+	}
+	p := make(color.Palette, 0, count)
+	for i := 0; i < count; i++ {
+		p = append(p, color.RGBA{data[i*3], data[i*3+1], data[i*3+2], 0xff})
+	}
+	return p
+}
+
+// parseHex parses one hex colour per line, with or without a leading #
+func parseHex(data []byte) (color.Palette, error) {
+	var p color.Palette
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "#")
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		rgb, err := hex.DecodeString(line)
+		if err != nil || len(rgb) != 3 {
+			return nil, fmt.Errorf("dither: bad hex palette colour %q", line)
+		}
+		p = append(p, color.RGBA{rgb[0], rgb[1], rgb[2], 0xff})
+	}
+	return p, scanner.Err()
+}
+
+// matcher finds the perceptually nearest palette colour, comparing colours
+// in CIE L*a*b* space
+type matcher struct {
+	colours []color.RGBA
+	labs    [][3]float64
+	cache   map[uint32]color.RGBA
+}
+
+func newMatcher(p color.Palette) *matcher {
+	m := &matcher{cache: make(map[uint32]color.RGBA)}
+	for _, c := range p {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		m.colours = append(m.colours, rgba)
+		m.labs = append(m.labs, lab(rgba.R, rgba.G, rgba.B))
+	}
+	return m
+}
+
+// nearest returns the palette colour closest to r, g, b
+func (m *matcher) nearest(r, g, b uint8) (uint8, uint8, uint8) {
+	key := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+	if c, ok := m.cache[key]; ok {
+		return c.R, c.G, c.B
+	}
+
+	target := lab(r, g, b)
+	best, bestDist := 0, math.Inf(1)
+	for i, l := range m.labs {
+		dl, da, db := l[0]-target[0], l[1]-target[1], l[2]-target[2]
+		if dist := dl*dl + da*da + db*db; dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+
+	c := m.colours[best]
+	m.cache[key] = c
+	return c.R, c.G, c.B
+}
+
+// lab converts an sRGB colour to CIE L*a*b* with a D65 white point
+func lab(r, g, b uint8) [3]float64 {
+	linear := func(v uint8) float64 {
+		c := float64(v) / 255
+		if c <= 0.04045 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	lr, lg, lb := linear(r), linear(g), linear(b)
+
+	x := (0.4124*lr + 0.3576*lg + 0.1805*lb) / 0.95047
+	y := 0.2126*lr + 0.7152*lg + 0.0722*lb
+	z := (0.0193*lr + 0.1192*lg + 0.9505*lb) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389.0 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27.0*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
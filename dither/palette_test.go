@@ -0,0 +1,154 @@
+package dither
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// act builds an Adobe Colour Table holding colours, with the count of colours
+// appended if withCount is set
+func act(colours []color.RGBA, withCount bool) []byte {
+	data := make([]byte, 768)
+	for i, c := range colours {
+		data[i*3], data[i*3+1], data[i*3+2] = c.R, c.G, c.B
+	}
+	if withCount {
+		data = append(data, byte(len(colours)>>8), byte(len(colours)), 0xff, 0xff)
+	}
+	return data
+}
+
+func TestLoadPalette(t *testing.T) {
+	red, lime, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0xff, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+	full := act([]color.RGBA{red, lime, blue}, false)
+
+	tests := []struct {
+		name string
+		data []byte
+		want color.Palette
+		err  bool
+	}{
+		{
+			name: "gpl",
+			data: []byte("GIMP Palette\nName: Test\nColumns: 3\n# comment\n255   0   0 Red\n  0 255   0\tLime\n\n  0   0 255 Blue\n"),
+			want: color.Palette{red, lime, blue},
+		},
+		{
+			name: "gpl short line",
+			data: []byte("GIMP Palette\n255 0\n"),
+			err:  true,
+		},
+		{
+			name: "gpl out of range",
+			data: []byte("GIMP Palette\n256 0 0 Too red\n"),
+			err:  true,
+		},
+		{
+			name: "gpl no colours",
+			data: []byte("GIMP Palette\nName: Empty\n"),
+			err:  true,
+		},
+		{
+			name: "act with count",
+			data: act([]color.RGBA{red, lime, blue}, true),
+			want: color.Palette{red, lime, blue},
+		},
+		{
+			name: "act without count",
+			data: full,
+			want: func() color.Palette {
+				p := color.Palette{red, lime, blue}
+				for len(p) < 256 {
+					p = append(p, color.RGBA{0, 0, 0, 0xff})
+				}
+				return p
+			}(),
+		},
+		{
+			name: "hex",
+			data: []byte("ff0000\n#00FF00\n; comment\n\n0000ff\n"),
+			want: color.Palette{red, lime, blue},
+		},
+		{
+			name: "hex bad digits",
+			data: []byte("ff0000\nzz0000\n"),
+			err:  true,
+		},
+		{
+			name: "hex wrong length",
+			data: []byte("ff00\n"),
+			err:  true,
+		},
+		{
+			name: "empty",
+			data: nil,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := LoadPalette(bytes.NewReader(tt.data))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p) != len(tt.want) {
+				t.Fatalf("got %d colours, want %d", len(p), len(tt.want))
+			}
+			for i := range tt.want {
+				if p[i] != tt.want[i] {
+					t.Errorf("colour %d is %v, want %v", i, p[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPaletteEmpty(t *testing.T) {
+	_, err := LoadPalette(strings.NewReader("; nothing here\n"))
+	if !errors.Is(err, ErrEmptyPalette) {
+		t.Errorf("got %v, want ErrEmptyPalette", err)
+	}
+}
+
+func TestBuiltInPalettes(t *testing.T) {
+	for name, p := range Palettes {
+		if len(p) < 2 {
+			t.Errorf("palette %q has %d colours", name, len(p))
+		}
+	}
+}
+
+func TestEmptyPaletteThresholds(t *testing.T) {
+	empty := color.Palette{}
+	tests := []struct {
+		name        string
+		withPalette func(*image.RGBA)
+		without     func(*image.RGBA)
+	}{
+		{"Pixelate", Pixelate{Palette: empty}.Dither, Pixelate{}.Dither},
+		{"Ordered", Ordered{Palette: empty}.Dither, Ordered{}.Dither},
+		{"Halftoner", Halftoner{Threshold: 128, Palette: empty}.Dither, Halftoner{Threshold: 128}.Dither},
+		{"Diffuser", Diffuser{Kernel: FloydSteinbergKernel, Threshold: 128, Palette: empty}.Dither, Diffuser{Kernel: FloydSteinbergKernel, Threshold: 128}.Dither},
+		{"Screen", Screen{Palette: empty}.Dither, Screen{}.Dither},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, want := testImage(image.Rect(0, 0, 16, 16)), testImage(image.Rect(0, 0, 16, 16))
+			tt.withPalette(got)
+			tt.without(want)
+			if !bytes.Equal(got.Pix, want.Pix) {
+				t.Error("an empty palette doesn't dither like no palette")
+			}
+		})
+	}
+}
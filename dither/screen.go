@@ -0,0 +1,209 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// DotShape is the shape of the dots in a halftone screen
+type DotShape int
+
+// Halftone dot shapes
+const (
+	// RoundDot grows from a circle into a checkerboard at 50% coverage
+	RoundDot DotShape = iota
+	// EllipticalDot is stretched along the screen, so dots join into chains
+	EllipticalDot
+	// LineDot is a line screen, with lines that thicken as the ink gets denser
+	LineDot
+)
+
+// DotShapes maps the names of the dot shapes to the shapes
+var DotShapes = map[string]DotShape{
+	"round":   RoundDot,
+	"ellipse": EllipticalDot,
+	"line":    LineDot,
+}
+
+// spot returns the order in which the point (u, v) of a cell is inked, from
+// 0 to 1, where u and v are between -0.5 and 0.5 from the centre of the cell
+func (s DotShape) spot(u, v float64) float64 {
+	switch s {
+	case EllipticalDot:
+		return 1 - (math.Cos(2*math.Pi*u)+.5*math.Cos(2*math.Pi*v)+1.5)/3
+	case LineDot:
+		return 2 * math.Abs(v)
+	default:
+		return 1 - (math.Cos(2*math.Pi*u)+math.Cos(2*math.Pi*v)+2)/4
+	}
+}
+
+// Separation is the set of inks a screen halftone is split into
+type Separation int
+
+// Halftone separations
+const (
+	// SeparateCMYK prints cyan, magenta, yellow and black onto white paper
+	SeparateCMYK Separation = iota
+	// SeparateRGB lights red, green and blue dots on a black background
+	SeparateRGB
+	// SeparateGray prints black onto white paper
+	SeparateGray
+)
+
+// Separations maps the names of the separations to the separations
+var Separations = map[string]Separation{
+	"cmyk": SeparateCMYK,
+	"rgb":  SeparateRGB,
+	"gray": SeparateGray,
+}
+
+// Default screen angles in degrees for each separation, in the order of its
+// inks. CMYKAngles are the classic print angles.
+var (
+	CMYKAngles = []float64{15, 75, 0, 45}
+	RGBAngles  = []float64{15, 75, 45}
+	GrayAngles = []float64{45}
+)
+
+// Screen is a print style halftone. The image is separated into inks, each
+// ink is printed as a grid of dots rotated to its own screen angle, and the
+// inks are composited back together.
+type Screen struct {
+	// Size is the distance between dots in pixels, 8 if not set
+	Size float64
+	// Shape is the shape of the dots
+	Shape DotShape
+	// Separation is the set of inks to split the image into
+	Separation Separation
+	// Angles are the screen angles in degrees of each ink, in the order C, M,
+	// Y, K or R, G, B. Inks without an angle use the defaults for the
+	// separation.
+	Angles []float64
+	// Palette is the set of colours to map the composited inks to
+	Palette color.Palette
+}
+
+// Dither halftones the image in place
+func (s Screen) Dither(destImage *image.RGBA) {
+	bounds := destImage.Bounds()
+	if bounds.Empty() {
+		return
+	}
+
+	size := s.Size
+	if size <= 0 {
+		size = 8
+	}
+
+	var angles []float64
+	switch s.Separation {
+	case SeparateRGB:
+		angles = append(angles, RGBAngles...)
+	case SeparateGray:
+		angles = append(angles, GrayAngles...)
+	default:
+		angles = append(angles, CMYKAngles...)
+	}
+	copy(angles, s.Angles)
+
+	source := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		copy(source.Pix[source.PixOffset(bounds.Min.X, y):], destImage.Pix[destImage.PixOffset(bounds.Min.X, y):destImage.PixOffset(bounds.Max.X, y)])
+	}
+
+	// ink returns the coverage of ink n, from 0 to 1, at a point in the image
+	ink := func(x, y float64, n int) float64 {
+		px := clampInt(int(math.Floor(x)), bounds.Min.X, bounds.Max.X-1)
+		py := clampInt(int(math.Floor(y)), bounds.Min.Y, bounds.Max.Y-1)
+		i := source.PixOffset(px, py)
+		r := float64(source.Pix[i]) / 255
+		g := float64(source.Pix[i+1]) / 255
+		b := float64(source.Pix[i+2]) / 255
+
+		switch s.Separation {
+		case SeparateRGB:
+			return [3]float64{r, g, b}[n]
+		case SeparateGray:
+			return 1 - (.3*r + .59*g + .11*b)
+		}
+		k := 1 - math.Max(r, math.Max(g, b))
+		if n == 3 {
+			return k
+		}
+		if k >= 1 {
+			return 0
+		}
+		return (1 - [3]float64{r, g, b}[n] - k) / (1 - k)
+	}
+
+	type screen struct{ sin, cos float64 }
+	screens := make([]screen, len(angles))
+	for n, angle := range angles {
+		rad := angle * math.Pi / 180
+		screens[n] = screen{math.Sin(rad), math.Cos(rad)}
+	}
+
+	var palette *matcher
+	if len(s.Palette) > 0 {
+		palette = newMatcher(s.Palette)
+	}
+
+	inked := make([]bool, len(screens))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			fx, fy := float64(x)+.5, float64(y)+.5
+			for n, sc := range screens {
+				// Find the cell of the rotated screen this pixel is in, and
+				// how much ink the image has at the centre of the cell
+				u := (fx*sc.cos + fy*sc.sin) / size
+				v := (-fx*sc.sin + fy*sc.cos) / size
+				cu, cv := math.Floor(u)+.5, math.Floor(v)+.5
+				cx := (cu*sc.cos - cv*sc.sin) * size
+				cy := (cu*sc.sin + cv*sc.cos) * size
+				inked[n] = s.Shape.spot(u-cu, v-cv) < ink(cx, cy, n)
+			}
+
+			var r, g, b uint8
+			switch s.Separation {
+			case SeparateRGB:
+				r, g, b = inkValue(inked[0]), inkValue(inked[1]), inkValue(inked[2])
+			case SeparateGray:
+				r = inkValue(!inked[0])
+				g, b = r, r
+			default:
+				r = inkValue(!inked[0] && !inked[3])
+				g = inkValue(!inked[1] && !inked[3])
+				b = inkValue(!inked[2] && !inked[3])
+			}
+			if palette != nil {
+				r, g, b = palette.nearest(r, g, b)
+			}
+
+			i := destImage.PixOffset(x, y)
+			destImage.Pix[i] = r
+			destImage.Pix[i+1] = g
+			destImage.Pix[i+2] = b
+		}
+	}
+}
+
+// inkValue returns a full channel if on, or an empty one
+func inkValue(on bool) uint8 {
+	if on {
+		return 0xff
+	}
+	return 0
+}
+
+// clampInt limits v to between lo and hi
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
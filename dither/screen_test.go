@@ -0,0 +1,44 @@
+package dither
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestScreenColours(t *testing.T) {
+	tests := []struct {
+		name   string
+		screen Screen
+		want   color.Palette
+	}{
+		{"cmyk", Screen{Size: 4}, color.Palette{
+			color.RGBA{0, 0, 0, 0xff}, color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0xff, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff},
+			color.RGBA{0xff, 0xff, 0, 0xff}, color.RGBA{0xff, 0, 0xff, 0xff}, color.RGBA{0, 0xff, 0xff, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff},
+		}},
+		{"gray", Screen{Size: 4, Separation: SeparateGray}, color.Palette{
+			color.RGBA{0, 0, 0, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff},
+		}},
+		{"palette", Screen{Size: 4, Palette: Palettes["gameboy"]}, Palettes["gameboy"]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := testImage(image.Rect(0, 0, 24, 24))
+			tt.screen.Dither(img)
+			for y := 0; y < 24; y++ {
+				for x := 0; x < 24; x++ {
+					c := img.RGBAAt(x, y)
+					found := false
+					for _, w := range tt.want {
+						if color.RGBAModel.Convert(w) == c {
+							found = true
+						}
+					}
+					if !found {
+						t.Fatalf("pixel %d,%d is %v, which isn't an ink", x, y, c)
+					}
+				}
+			}
+		})
+	}
+}
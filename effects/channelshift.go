@@ -0,0 +1,123 @@
+package effects
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// EdgeMode is what ChannelShift does with channels shifted in from outside
+// the image
+type EdgeMode int
+
+// Edge modes
+const (
+	// EdgeWrap takes the channel from the opposite side of the image
+	EdgeWrap EdgeMode = iota
+	// EdgeClamp repeats the channel at the edge of the image
+	EdgeClamp
+	// EdgeTransparent leaves the channel empty, and the pixel transparent if
+	// every channel is empty
+	EdgeTransparent
+)
+
+// EdgeModes maps the names of the edge modes to the modes
+var EdgeModes = map[string]EdgeMode{
+	"wrap":        EdgeWrap,
+	"clamp":       EdgeClamp,
+	"transparent": EdgeTransparent,
+}
+
+// ChannelShiftOptions configures how far each colour channel is shifted. The
+// channels are in the order red, green, blue.
+type ChannelShiftOptions struct {
+	// Offsets move each channel by a fixed number of pixels
+	Offsets [3]image.Point
+	// Radial scales each channel out from the centre of the image by this
+	// fraction, so the shift grows towards the edges like the chromatic
+	// aberration of a cheap lens. Negative values, down to but not including
+	// -1, pull the channel inwards.
+	Radial [3]float64
+	// Edge is what to do with channels shifted in from outside the image
+	Edge EdgeMode
+}
+
+// ChannelShift splits the colour channels of sourceImage apart, writing the
+// result to destImage. Alpha isn't shifted.
+func ChannelShift(destImage *image.RGBA, sourceImage *image.RGBA, opts ChannelShiftOptions) {
+	ChannelShiftContext(context.Background(), destImage, sourceImage, opts)
+}
+
+// ChannelShiftContext is like ChannelShift, but stops early and returns
+// ctx.Err() if ctx is cancelled, leaving destImage untouched
+func ChannelShiftContext(ctx context.Context, destImage *image.RGBA, sourceImage *image.RGBA, opts ChannelShiftOptions) error {
+	bounds := sourceImage.Bounds().Intersect(destImage.Bounds())
+	if bounds.Empty() {
+		return nil
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	cx, cy := float64(bounds.Min.X)+float64(width)/2, float64(bounds.Min.Y)+float64(height)/2
+
+	// sample finds the source coordinate along one axis, or false if it is
+	// outside the image with transparent edges
+	sample := func(v, min, size int) (int, bool) {
+		if v >= min && v < min+size {
+			return v, true
+		}
+		switch opts.Edge {
+		case EdgeClamp:
+			if v < min {
+				return min, true
+			}
+			return min + size - 1, true
+		case EdgeTransparent:
+			return 0, false
+		}
+		return min + ((v-min)%size+size)%size, true
+	}
+
+	// Write to a copy in case the images overlap
+	out := make([]uint8, 4*width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			o := 4 * ((y-bounds.Min.Y)*width + (x - bounds.Min.X))
+			i := sourceImage.PixOffset(x, y)
+			alpha := sourceImage.Pix[i+3]
+
+			shifted := 0
+			for c := 0; c < 3; c++ {
+				sx := float64(x) + .5 - float64(opts.Offsets[c].X)
+				sy := float64(y) + .5 - float64(opts.Offsets[c].Y)
+				if r := opts.Radial[c]; r != 0 && r > -1 {
+					sx = cx + (sx-cx)/(1+r)
+					sy = cy + (sy-cy)/(1+r)
+				}
+				px, okX := sample(int(math.Floor(sx)), bounds.Min.X, width)
+				py, okY := sample(int(math.Floor(sy)), bounds.Min.Y, height)
+				if !okX || !okY {
+					continue
+				}
+				shifted++
+				v := sourceImage.Pix[sourceImage.PixOffset(px, py)+c]
+				if v > alpha {
+					// Keep the premultiplied colour valid
+					v = alpha
+				}
+				out[o+c] = v
+			}
+			if shifted > 0 {
+				out[o+3] = alpha
+			}
+		}
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := destImage.PixOffset(bounds.Min.X, y)
+		o := 4 * (y - bounds.Min.Y) * width
+		copy(destImage.Pix[i:i+4*width], out[o:o+4*width])
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package effects
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// PhosphorMask is the pattern of coloured phosphors on a CRT screen
+type PhosphorMask int
+
+// Phosphor masks
+const (
+	// MaskNone has no visible phosphors
+	MaskNone PhosphorMask = iota
+	// ApertureGrille has unbroken vertical stripes of red, green and blue,
+	// like a Trinitron
+	ApertureGrille
+	// ShadowMask has triads of dots, each row offset by half a triad
+	ShadowMask
+	// SlotMask has short vertical slots, staggered like bricks
+	SlotMask
+)
+
+// PhosphorMasks maps the names of the phosphor masks to the masks
+var PhosphorMasks = map[string]PhosphorMask{
+	"none":     MaskNone,
+	"aperture": ApertureGrille,
+	"shadow":   ShadowMask,
+	"slot":     SlotMask,
+}
+
+// bloomThreshold is the brightness (0-1) above which pixels bloom
+const bloomThreshold = 0.6
+
+// CRTOptions configures CRT. Zero values turn each part of the effect off.
+type CRTOptions struct {
+	// ScanlineDarkness is how dark (0-1) the gaps between scanlines are
+	ScanlineDarkness float64
+	// ScanlineThickness is the fraction (0-1) of each scanline that is gap
+	ScanlineThickness float64
+	// ScanlinePeriod is the height of each scanline in pixels. If it is 0,
+	// scanlines are 2 pixels high like ApplyScanlines.
+	ScanlinePeriod float64
+	// Mask is the phosphor pattern, darkening the other channels by
+	// MaskStrength (0-1). Each phosphor is MaskSize pixels wide, or 1 if
+	// MaskSize is 0.
+	Mask         PhosphorMask
+	MaskStrength float64
+	MaskSize     int
+	// Curvature is how much (0-1) the screen bulges, like the glass of a
+	// CRT. The corners of the picture fall off the screen.
+	Curvature float64
+	// Vignette is how much (0-1) the edges of the screen darken
+	Vignette float64
+	// Bloom is the strength (0-1) of the glow around bright pixels, spread
+	// BloomRadius pixels. If BloomRadius is 0 it is 1/50th of the image.
+	Bloom       float64
+	BloomRadius int
+	// Corners is the radius of the rounded corners of the screen, as a
+	// fraction (0-1) of its shorter side
+	Corners float64
+}
+
+// DefaultCRT returns the settings of a typical, slightly worn CRT
+func DefaultCRT() CRTOptions {
+	return CRTOptions{
+		ScanlineDarkness:  0.5,
+		ScanlineThickness: 0.4,
+		ScanlinePeriod:    3,
+		Mask:              ApertureGrille,
+		MaskStrength:      0.3,
+		MaskSize:          1,
+		Curvature:         0.2,
+		Vignette:          0.3,
+		Bloom:             0.4,
+		Corners:           0.05,
+	}
+}
+
+// CRT makes sourceImage look like it is shown on a CRT, writing the result
+// to destImage, which may be sourceImage. The bezel around the curved screen
+// is black.
+func CRT(destImage, sourceImage *image.RGBA, opts CRTOptions) {
+	CRTContext(context.Background(), destImage, sourceImage, opts)
+}
+
+// CRTContext is like CRT, but stops early and returns ctx.Err() if ctx is
+// cancelled, leaving the rest of the rows untouched
+func CRTContext(ctx context.Context, destImage, sourceImage *image.RGBA, opts CRTOptions) error {
+	bounds := sourceImage.Bounds().Intersect(destImage.Bounds())
+	if bounds.Empty() {
+		return nil
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	w, h := float64(width), float64(height)
+
+	// Copy the source, so destImage can be written as we go
+	src := make([]float64, 4*width*height)
+	for y := 0; y < height; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := 0; x < width; x++ {
+			i := sourceImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			for c := 0; c < 4; c++ {
+				src[4*(y*width+x)+c] = float64(sourceImage.Pix[i+c])
+			}
+		}
+	}
+
+	// bilinear samples the source at a pixel position, repeating the edges
+	bilinear := func(px, py float64, out *[4]float64) {
+		px, py = px-.5, py-.5
+		x0, y0 := math.Floor(px), math.Floor(py)
+		fx, fy := px-x0, py-y0
+		ix0, iy0 := clampInt(int(x0), 0, width-1), clampInt(int(y0), 0, height-1)
+		ix1, iy1 := clampInt(int(x0)+1, 0, width-1), clampInt(int(y0)+1, 0, height-1)
+		for c := 0; c < 4; c++ {
+			top := src[4*(iy0*width+ix0)+c]*(1-fx) + src[4*(iy0*width+ix1)+c]*fx
+			bottom := src[4*(iy1*width+ix0)+c]*(1-fx) + src[4*(iy1*width+ix1)+c]*fx
+			out[c] = top*(1-fy) + bottom*fy
+		}
+	}
+
+	// Bend the picture onto the glass, keeping where each pixel came from
+	// for the scanlines, and how much of it is on the screen
+	screen := make([]float64, 4*width*height)
+	sourceY := make([]float64, width*height)
+	coverage := make([]float64, width*height)
+	radius := opts.Corners * math.Min(w, h) / 2
+	k := opts.Curvature / 4
+	var sample [4]float64
+	for y := 0; y < height; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := 0; x < width; x++ {
+			nx, ny := 2*(float64(x)+.5)/w-1, 2*(float64(y)+.5)/h-1
+			bend := 1 + k*(nx*nx+ny*ny)
+			sx, sy := (nx*bend+1)*w/2, (ny*bend+1)*h/2
+			p := y*width + x
+
+			// Distance inside the rounded screen edge, in pixels
+			dx := math.Max(radius-sx, sx-(w-radius))
+			dy := math.Max(radius-sy, sy-(h-radius))
+			var inside float64
+			if dx > 0 && dy > 0 {
+				inside = radius - math.Hypot(dx, dy)
+			} else {
+				inside = math.Min(math.Min(sx, w-sx), math.Min(sy, h-sy))
+			}
+			coverage[p] = math.Max(0, math.Min(1, inside*bend+.5))
+			if coverage[p] == 0 {
+				continue
+			}
+
+			bilinear(sx, sy, &sample)
+			copy(screen[4*p:4*p+4], sample[:])
+			sourceY[p] = sy
+		}
+	}
+
+	// Glow around the bright parts of the picture
+	var bloom []float64
+	if opts.Bloom > 0 {
+		bloom = make([]float64, 3*width*height)
+		for p := 0; p < width*height; p++ {
+			r, g, b := screen[4*p], screen[4*p+1], screen[4*p+2]
+			l := (0.299*r + 0.587*g + 0.114*b) / 255
+			if l <= bloomThreshold {
+				continue
+			}
+			glow := (l - bloomThreshold) / (1 - bloomThreshold)
+			bloom[3*p], bloom[3*p+1], bloom[3*p+2] = r*glow, g*glow, b*glow
+		}
+		spread := opts.BloomRadius
+		if spread <= 0 {
+			spread = int(math.Max(1, math.Min(w, h)/50))
+		}
+		// Two box blurs each way are close enough to a Gaussian
+		for pass := 0; pass < 2; pass++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			boxBlur(bloom, width, height, spread, 1, 0)
+			boxBlur(bloom, width, height, spread, 0, 1)
+		}
+	}
+
+	period := opts.ScanlinePeriod
+	if period <= 0 {
+		period = 2
+	}
+	size := opts.MaskSize
+	if size <= 0 {
+		size = 1
+	}
+
+	for y := 0; y < height; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := 0; x < width; x++ {
+			p := y*width + x
+			i := destImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			if coverage[p] == 0 {
+				destImage.Pix[i], destImage.Pix[i+1], destImage.Pix[i+2], destImage.Pix[i+3] = 0, 0, 0, 0xff
+				continue
+			}
+
+			scan := 1 - opts.ScanlineDarkness*scanlineGap(sourceY[p], period, opts.ScanlineThickness)
+			nx, ny := 2*(float64(x)+.5)/w-1, 2*(float64(y)+.5)/h-1
+			vignette := 1 - opts.Vignette*math.Min(1, (nx*nx+ny*ny)/2)
+			phosphor := phosphorChannel(opts.Mask, x, y, size)
+
+			// Off the screen is the black bezel
+			alpha := clampChannel(screen[4*p+3]*coverage[p]+(1-coverage[p])*255, 0xff)
+			for c := 0; c < 3; c++ {
+				v := screen[4*p+c] * scan
+				if phosphor >= 0 && phosphor != c {
+					v *= 1 - opts.MaskStrength
+				}
+				if bloom != nil {
+					v += opts.Bloom * bloom[3*p+c]
+				}
+				destImage.Pix[i+c] = clampChannel(v*vignette*coverage[p], alpha)
+			}
+			destImage.Pix[i+3] = alpha
+		}
+	}
+	return nil
+}
+
+// scanlineGap returns how much (0-1) of the pixel row centred on y falls in
+// the gap at the top of each scanline, period pixels apart
+func scanlineGap(y, period, thickness float64) float64 {
+	if thickness <= 0 {
+		return 0
+	}
+	// Average a few samples down the row to soften the edges
+	const samples = 4
+	var gap float64
+	for s := 0.0; s < samples; s++ {
+		f := math.Mod(y-.5+(s+.5)/samples, period) / period
+		if f < 0 {
+			f++
+		}
+		if f < thickness {
+			gap++
+		}
+	}
+	return gap / samples
+}
+
+// phosphorChannel returns which colour channel's phosphor is at x, y, or -1
+// if it is between phosphors or there is no mask
+func phosphorChannel(mask PhosphorMask, x, y, size int) int {
+	switch mask {
+	case ApertureGrille:
+		return (x / size) % 3
+	case ShadowMask:
+		// Every other row of dots is shifted along by half a triad
+		if (y/size)%2 == 1 {
+			x += 3 * size / 2
+		}
+		return (x / size) % 3
+	case SlotMask:
+		// Slots are three phosphors tall with a gap below, and each triad
+		// is staggered half a slot from the last
+		triad := x / (3 * size)
+		row := y + triad%2*2*size
+		if row%(4*size) >= 3*size {
+			return -1
+		}
+		return (x / size) % 3
+	}
+	return -1
+}
+
+// boxBlur blurs an image of 3 channel floats in place along dx, dy
+func boxBlur(values []float64, width, height, radius, dx, dy int) {
+	length, lines := width, height
+	if dy != 0 {
+		length, lines = height, width
+	}
+	at := func(line, i int) int {
+		if dy != 0 {
+			return 3 * (i*width + line)
+		}
+		return 3 * (line*width + i)
+	}
+
+	row := make([]float64, 3*length)
+	for line := 0; line < lines; line++ {
+		for i := 0; i < length; i++ {
+			copy(row[3*i:3*i+3], values[at(line, i):at(line, i)+3])
+		}
+		for c := 0; c < 3; c++ {
+			// Running sum over the window, repeating the edge values
+			var sum float64
+			for i := -radius; i <= radius; i++ {
+				sum += row[3*clampInt(i, 0, length-1)+c]
+			}
+			for i := 0; i < length; i++ {
+				values[at(line, i)+c] = sum / float64(2*radius+1)
+				sum += row[3*clampInt(i+radius+1, 0, length-1)+c] - row[3*clampInt(i-radius, 0, length-1)+c]
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package effects
+
+import (
+	"context"
+	"image"
+	"image/draw"
+
+	"github.com/darkliquid/glitch/utils"
+)
+
+// DatamoshOptions configures Datamosh
+type DatamoshOptions struct {
+	// BlockSize is the width and height of a macroblock, usually 8 or 16
+	BlockSize int
+	// MaxOffset is the largest distance in pixels a motion vector moves a block
+	MaxOffset int
+	// Displace is the chance (0-1) of a block being copied from a displaced position
+	Displace float64
+	// Repeat is the chance (0-1) of a block being smeared along its motion vector
+	Repeat float64
+	// RepeatLength is the most times a smeared block is repeated
+	RepeatLength int
+	// Stale is the chance (0-1) of a block keeping its content from the
+	// previous frame, as if it was never updated
+	Stale float64
+}
+
+// Datamosh breaks the image into macroblocks and shuffles them around like a
+// video codec with corrupted motion vectors. sourceImage is the frame being
+// encoded and previousImage, if not nil, is the frame before it, which
+// displaced and stale blocks are taken from. The result is written to destImage.
+func Datamosh(rng utils.Rand, destImage, sourceImage, previousImage *image.RGBA, opts DatamoshOptions) {
+	DatamoshContext(context.Background(), rng, destImage, sourceImage, previousImage, opts)
+}
+
+// DatamoshContext is like Datamosh, but stops early and returns ctx.Err() if
+// ctx is cancelled, leaving the rest of the blocks untouched
+func DatamoshContext(ctx context.Context, rng utils.Rand, destImage, sourceImage, previousImage *image.RGBA, opts DatamoshOptions) error {
+	bounds := sourceImage.Bounds()
+	size := opts.BlockSize
+	if size <= 0 {
+		size = 16
+	}
+
+	reference := sourceImage
+	if previousImage != nil {
+		reference = previousImage
+	}
+
+	draw.Draw(destImage, bounds, sourceImage, bounds.Min, draw.Src)
+
+	// Blocks mostly share one motion vector, with some per-block jitter, so
+	// the image smears in a consistent direction like real motion
+	globalX := utils.Random(rng, -opts.MaxOffset, opts.MaxOffset+1)
+	globalY := utils.Random(rng, -opts.MaxOffset, opts.MaxOffset+1)
+	jitter := opts.MaxOffset/4 + 1
+
+	copyBlock := func(dx, dy int, src *image.RGBA, sx, sy int) {
+		r := image.Rect(dx, dy, dx+size, dy+size).Intersect(bounds)
+		if r.Empty() {
+			return
+		}
+		draw.Draw(destImage, r, src, image.Pt(sx+r.Min.X-dx, sy+r.Min.Y-dy), draw.Src)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x += size {
+			if previousImage != nil && float64(rng.Float32()) < opts.Stale {
+				copyBlock(x, y, previousImage, x, y)
+				continue
+			}
+
+			vx := globalX + utils.Random(rng, -jitter, jitter+1)
+			vy := globalY + utils.Random(rng, -jitter, jitter+1)
+
+			if float64(rng.Float32()) < opts.Displace {
+				copyBlock(x, y, reference, clampBlock(x-vx, bounds.Min.X, bounds.Max.X-size), clampBlock(y-vy, bounds.Min.Y, bounds.Max.Y-size))
+			}
+
+			if opts.RepeatLength > 0 && (vx != 0 || vy != 0) && float64(rng.Float32()) < opts.Repeat {
+				n := utils.Random(rng, 1, opts.RepeatLength+1)
+				for i := 1; i <= n; i++ {
+					copyBlock(x+vx*i, y+vy*i, destImage, x, y)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// clampBlock keeps a block origin inside the image
+func clampBlock(v, min, max int) int {
+	if v > max {
+		v = max
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}
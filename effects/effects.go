@@ -9,43 +9,41 @@ import (
 	"github.com/darkliquid/glitch/utils"
 )
 
-// WrapSlice wraps a slice of the image horizontally either left or right
+// WrapSlice wraps a slice of the image horizontally either left or right.
+// yPos is in image coordinates, so it starts at Bounds().Min.Y.
 func WrapSlice(destImage *image.RGBA, sourceImage *image.RGBA, xShift int, yPos int, height int, mask image.Image, op draw.Op) {
 	if xShift == 0 {
 		return
 	}
 
-	width := sourceImage.Bounds().Max.X
+	bounds := sourceImage.Bounds()
+	left, right := bounds.Min.X, bounds.Max.X
 
 	// Wrap slice left
 	if xShift < 0 {
-		r := image.Rect(-xShift, yPos, width, yPos+height)
-		p := image.Pt(0, yPos)
+		r := image.Rect(left-xShift, yPos, right, yPos+height)
+		p := image.Pt(left, yPos)
 		draw.DrawMask(destImage, r, sourceImage, p, mask, p, op)
 
-		r = image.Rect(0, yPos, -xShift, yPos+height)
-		p = image.Pt(width+xShift, yPos)
+		r = image.Rect(left, yPos, left-xShift, yPos+height)
+		p = image.Pt(right+xShift, yPos)
 		draw.DrawMask(destImage, r, sourceImage, p, mask, p, op)
 		// Wrap slice right
 	} else {
-		r := image.Rect(0, yPos, width, yPos+height)
-		p := image.Pt(xShift, yPos)
+		r := image.Rect(left, yPos, right, yPos+height)
+		p := image.Pt(left+xShift, yPos)
 		draw.DrawMask(destImage, r, sourceImage, p, mask, p, op)
 
-		r = image.Rect(width-xShift, yPos, width, yPos+height)
-		p = image.Pt(0, yPos)
+		r = image.Rect(right-xShift, yPos, right, yPos+height)
+		p = image.Pt(left, yPos)
 		draw.DrawMask(destImage, r, sourceImage, p, mask, p, op)
 	}
 }
 
-// ApplyScanlines applies scanlines
+// ApplyScanlines applies scanlines, blacking out every other row. Use
+// Scanlines for other patterns.
 func ApplyScanlines(destImage *image.RGBA) {
-	bounds := destImage.Bounds()
-	for y := bounds.Min.Y; y < bounds.Max.Y; y = y + 2 {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			destImage.Set(x, y, color.Black)
-		}
-	}
+	Scanlines(destImage, DefaultScanlines())
 }
 
 // ApplyBrightness increases brightness of image by brightness factor
@@ -92,3 +90,34 @@ func CopyChannel(destImage *image.RGBA, sourceImage *image.RGBA, copyChannel uti
 		}
 	}
 }
+
+// ApplyMask blends destImage back towards originalImage wherever the mask is
+// weak. White or opaque parts of the mask keep destImage, black or
+// transparent parts restore originalImage and anything in between is a mix.
+// The mask is stretched to fit if it isn't the same size as destImage.
+func ApplyMask(destImage *image.RGBA, originalImage *image.RGBA, mask image.Image) {
+	bounds := destImage.Bounds()
+	maskBounds := mask.Bounds()
+	if bounds.Empty() || maskBounds.Empty() {
+		return
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		my := maskBounds.Min.Y + (y-bounds.Min.Y)*maskBounds.Dy()/bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			mx := maskBounds.Min.X + (x-bounds.Min.X)*maskBounds.Dx()/bounds.Dx()
+			// Gray of a premultiplied colour accounts for alpha masks too
+			strength := uint32(color.Gray16Model.Convert(mask.At(mx, my)).(color.Gray16).Y)
+			if strength == 0xffff {
+				continue
+			}
+
+			i := destImage.PixOffset(x, y)
+			o := originalImage.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				glitched, original := uint32(destImage.Pix[i+c]), uint32(originalImage.Pix[o+c])
+				destImage.Pix[i+c] = uint8((glitched*strength + original*(0xffff-strength)) / 0xffff)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package effects
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/darkliquid/glitch/utils"
+)
+
+// SortKey is the pixel property PixelSort orders pixels by
+type SortKey int
+
+const (
+	// SortBrightness sorts by perceived brightness
+	SortBrightness SortKey = iota
+	// SortHue sorts by hue
+	SortHue
+	// SortSaturation sorts by saturation
+	SortSaturation
+)
+
+// Value returns the sort key of a colour in the range 0-1
+func (k SortKey) Value(r, g, b uint8) float64 {
+	switch k {
+	case SortHue:
+		h, _, _ := utils.HSV(r, g, b)
+		return h
+	case SortSaturation:
+		_, s, _ := utils.HSV(r, g, b)
+		return s
+	}
+	return utils.Luminance(r, g, b)
+}
+
+// PixelSortOptions configures PixelSort
+type PixelSortOptions struct {
+	// Key is what the pixels in each interval are sorted by
+	Key SortKey
+	// Angle is the direction pixels are sorted along in degrees, where 0 is
+	// left to right and 90 is top to bottom
+	Angle float64
+	// Lower and Upper bound the brightness (0-1) of the pixels that make up
+	// an interval. Runs of pixels within the range are sorted, anything
+	// outside it is left alone and breaks the run.
+	Lower, Upper float64
+	// Reverse sorts from high to low instead of low to high
+	Reverse bool
+	// Mask limits sorting to pixels where the mask is mostly white or opaque,
+	// like ApplyMask
+	Mask image.Image
+}
+
+// PixelSort sorts runs of pixels in the image along lines at the given angle
+func PixelSort(destImage *image.RGBA, opts PixelSortOptions) {
+	PixelSortContext(context.Background(), destImage, opts)
+}
+
+// PixelSortContext is like PixelSort, but stops early and returns ctx.Err()
+// if ctx is cancelled, leaving the image partly sorted
+func PixelSortContext(ctx context.Context, destImage *image.RGBA, opts PixelSortOptions) error {
+	bounds := destImage.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+
+	// Every pixel belongs to the line perpendicular distance d from the
+	// origin and sits at position t along it
+	theta := opts.Angle * math.Pi / 180
+	cos, sin := math.Cos(theta), math.Sin(theta)
+	if math.Abs(cos) < 1e-9 {
+		cos = 0
+	}
+	if math.Abs(sin) < 1e-9 {
+		sin = 0
+	}
+
+	type linePoint struct {
+		t      float64
+		offset int
+		masked bool
+	}
+	lines := make(map[int][]linePoint)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			d := int(math.Round(-float64(x)*sin + float64(y)*cos))
+			masked := false
+			if opts.Mask != nil {
+				// Gray of a premultiplied colour accounts for alpha masks too
+				masked = color.Gray16Model.Convert(opts.Mask.At(x, y)).(color.Gray16).Y < 0x8000
+			}
+			lines[d] = append(lines[d], linePoint{
+				t:      float64(x)*cos + float64(y)*sin,
+				offset: destImage.PixOffset(x, y),
+				masked: masked,
+			})
+		}
+	}
+
+	type pixel struct {
+		key        float64
+		r, g, b, a uint8
+	}
+	var run []int
+	var pixels []pixel
+
+	sortRun := func() {
+		if len(run) > 1 {
+			pixels = pixels[:0]
+			for _, i := range run {
+				p := destImage.Pix[i : i+4 : i+4]
+				pixels = append(pixels, pixel{opts.Key.Value(p[0], p[1], p[2]), p[0], p[1], p[2], p[3]})
+			}
+			sort.SliceStable(pixels, func(i, j int) bool {
+				if opts.Reverse {
+					return pixels[i].key > pixels[j].key
+				}
+				return pixels[i].key < pixels[j].key
+			})
+			for n, i := range run {
+				p := destImage.Pix[i : i+4 : i+4]
+				p[0], p[1], p[2], p[3] = pixels[n].r, pixels[n].g, pixels[n].b, pixels[n].a
+			}
+		}
+		run = run[:0]
+	}
+
+	for _, line := range lines {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sort.Slice(line, func(i, j int) bool { return line[i].t < line[j].t })
+		for _, point := range line {
+			p := destImage.Pix[point.offset : point.offset+4 : point.offset+4]
+			brightness := utils.Luminance(p[0], p[1], p[2])
+			if point.masked || brightness < opts.Lower || brightness > opts.Upper {
+				sortRun()
+				continue
+			}
+			run = append(run, point.offset)
+		}
+		sortRun()
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package effects
+
+import (
+	"image"
+	"image/color"
+)
+
+// ScanlineOrientation is the direction scanlines run in
+type ScanlineOrientation int
+
+// Scanline orientations
+const (
+	ScanlinesHorizontal ScanlineOrientation = iota
+	ScanlinesVertical
+	// ScanlinesDiagonal runs at 45 degrees, down to the left
+	ScanlinesDiagonal
+)
+
+// ScanlineOrientations maps the names of the orientations to the orientations
+var ScanlineOrientations = map[string]ScanlineOrientation{
+	"horizontal": ScanlinesHorizontal,
+	"vertical":   ScanlinesVertical,
+	"diagonal":   ScanlinesDiagonal,
+}
+
+// BlendMode is how a colour is combined with the image under it
+type BlendMode int
+
+// Blend modes
+const (
+	// BlendNormal paints the colour over the image
+	BlendNormal BlendMode = iota
+	// BlendMultiply darkens the image by the colour
+	BlendMultiply
+	// BlendScreen lightens the image by the colour
+	BlendScreen
+)
+
+// BlendModes maps the names of the blend modes to the modes
+var BlendModes = map[string]BlendMode{
+	"normal":   BlendNormal,
+	"multiply": BlendMultiply,
+	"screen":   BlendScreen,
+}
+
+// ScanlineOptions configures Scanlines
+type ScanlineOptions struct {
+	// Period is the distance in pixels from the start of one line to the
+	// next, and Thickness is how many of those pixels the line covers
+	Period    int
+	Thickness int
+	// Phase moves the lines along by this many pixels
+	Phase int
+	// Opacity is how strongly (0-1) the lines are blended with the image
+	Opacity float64
+	Blend   BlendMode
+	Color   color.Color
+	// Orientation is the direction the lines run in
+	Orientation ScanlineOrientation
+	// Jitter shifts every other row, or column for vertical lines, this many
+	// pixels along, like the two fields of an interlaced picture that don't
+	// quite line up
+	Jitter int
+}
+
+// DefaultScanlines returns the settings of ApplyScanlines, a solid black line
+// on every other row
+func DefaultScanlines() ScanlineOptions {
+	return ScanlineOptions{
+		Period:    2,
+		Thickness: 1,
+		Opacity:   1,
+		Color:     color.Black,
+	}
+}
+
+// Scanlines draws lines across destImage. Nothing is drawn if the period is
+// less than 1.
+func Scanlines(destImage *image.RGBA, opts ScanlineOptions) {
+	bounds := destImage.Bounds()
+	if bounds.Empty() || opts.Period < 1 {
+		return
+	}
+	vertical := opts.Orientation == ScanlinesVertical
+
+	if opts.Jitter != 0 {
+		interlace(destImage, opts.Jitter, opts.Phase, vertical)
+	}
+
+	c := opts.Color
+	if c == nil {
+		c = color.Black
+	}
+	r, g, b, a := c.RGBA()
+	ink := [4]uint32{r >> 8, g >> 8, b >> 8, a >> 8}
+	opacity := uint32(clampFloat(opts.Opacity, 0, 1)*0xff + .5)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var t int
+			switch opts.Orientation {
+			case ScanlinesVertical:
+				t = x - bounds.Min.X
+			case ScanlinesDiagonal:
+				t = x - bounds.Min.X + y - bounds.Min.Y
+			default:
+				t = y - bounds.Min.Y
+			}
+			if wrapCoord(t-opts.Phase, opts.Period) >= opts.Thickness {
+				continue
+			}
+
+			i := destImage.PixOffset(x, y)
+			pix := destImage.Pix[i : i+4 : i+4]
+			dstA := uint32(pix[3])
+			for ch := 0; ch < 4; ch++ {
+				dst := uint32(pix[ch])
+				var blended uint32
+				switch opts.Blend {
+				case BlendMultiply:
+					if ch == 3 {
+						blended = dst
+					} else {
+						blended = dst * ink[ch] / 0xff
+					}
+				case BlendScreen:
+					if ch == 3 {
+						blended = dst
+					} else {
+						// Scale the ink by the alpha to keep the colour premultiplied
+						blended = dst + ink[ch]*dstA/0xff - dst*ink[ch]/0xff
+					}
+				default:
+					// The ink drawn over the image
+					blended = ink[ch] + dst*(0xff-ink[3])/0xff
+				}
+				pix[ch] = uint8((blended*opacity + dst*(0xff-opacity) + 0x7f) / 0xff)
+			}
+		}
+	}
+}
+
+// interlace shifts every other row of img, or column if vertical is set,
+// shift pixels along, repeating the edge pixels. The rows shifted are the
+// odd ones, counting from phase.
+func interlace(img *image.RGBA, shift, phase int, vertical bool) {
+	bounds := img.Bounds()
+	lines, length := bounds.Dy(), bounds.Dx()
+	if vertical {
+		lines, length = length, lines
+	}
+	at := func(line, i int) int {
+		if vertical {
+			return img.PixOffset(bounds.Min.X+line, bounds.Min.Y+i)
+		}
+		return img.PixOffset(bounds.Min.X+i, bounds.Min.Y+line)
+	}
+
+	buf := make([]uint8, 4*length)
+	for line := 0; line < lines; line++ {
+		if wrapCoord(line-phase, 2) == 0 {
+			continue
+		}
+		for i := 0; i < length; i++ {
+			o := at(line, i)
+			copy(buf[4*i:4*i+4], img.Pix[o:o+4])
+		}
+		for i := 0; i < length; i++ {
+			s := 4 * clampInt(i-shift, 0, length-1)
+			o := at(line, i)
+			copy(img.Pix[o:o+4], buf[s:s+4])
+		}
+	}
+}
+
+// clampFloat limits v to between lo and hi
+func clampFloat(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
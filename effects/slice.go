@@ -0,0 +1,194 @@
+package effects
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// SliceShape is the outline of a slice moved by ShiftSlice
+type SliceShape int
+
+// Slice shapes
+const (
+	// SliceStraight has straight, parallel edges
+	SliceStraight SliceShape = iota
+	// SliceWedge tapers from nothing at one end to its full thickness
+	SliceWedge
+	// SliceJagged has ragged, torn edges
+	SliceJagged
+)
+
+// SliceShapes maps the names of the slice shapes to the shapes
+var SliceShapes = map[string]SliceShape{
+	"straight": SliceStraight,
+	"wedge":    SliceWedge,
+	"jagged":   SliceJagged,
+}
+
+// OffsetCurve is how the offset of a slice varies across it
+type OffsetCurve int
+
+// Offset curves
+const (
+	// CurveUniform moves the whole slice by the same amount
+	CurveUniform OffsetCurve = iota
+	// CurveSine follows a sine wave, for a smooth ripple
+	CurveSine
+	// CurveNoise follows smooth random noise, for a wobbly tear
+	CurveNoise
+)
+
+// OffsetCurves maps the names of the offset curves to the curves
+var OffsetCurves = map[string]OffsetCurve{
+	"uniform": CurveUniform,
+	"sine":    CurveSine,
+	"noise":   CurveNoise,
+}
+
+// SliceOptions describes a slice for ShiftSlice. The slice runs along Angle,
+// and is moved along that same direction, wrapping around the image.
+type SliceOptions struct {
+	// Angle is the direction of the slice in degrees. 0 is a horizontal band
+	// moved sideways like WrapSlice, 90 is a column moved up and down.
+	Angle float64
+	// Position is where the slice starts across its direction, in pixels
+	// from the corner of the image where that distance is smallest. For an
+	// angle of 0 that is the top of the image.
+	Position int
+	// Thickness is the width of the slice across its direction
+	Thickness int
+	// Offset is how far along the slice to take pixels from
+	Offset int
+	// Shape is the outline of the slice
+	Shape SliceShape
+	// Curve is how the offset varies across the slice
+	Curve OffsetCurve
+	// Period is the wavelength of the sine or noise curve in pixels. If it is
+	// 0 the thickness of the slice is used.
+	Period float64
+}
+
+// ShiftSlice moves a slice of sourceImage along its direction, drawing it
+// onto destImage through the mask with the given op, like WrapSlice
+func ShiftSlice(destImage *image.RGBA, sourceImage *image.RGBA, opts SliceOptions, mask image.Image, op draw.Op) {
+	bounds := sourceImage.Bounds().Intersect(destImage.Bounds())
+	if bounds.Empty() || opts.Thickness <= 0 {
+		return
+	}
+	if sourceImage == destImage {
+		// Read from a copy so moved pixels aren't moved again
+		sourceImage = &image.RGBA{
+			Pix:    append([]uint8(nil), sourceImage.Pix...),
+			Stride: sourceImage.Stride,
+			Rect:   sourceImage.Rect,
+		}
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+
+	rad := opts.Angle * math.Pi / 180
+	dirX, dirY := math.Cos(rad), math.Sin(rad)
+
+	// Measure positions across the slice from the nearest corner, and along
+	// it from the first corner, so both start at 0
+	across := func(x, y float64) float64 { return -x*dirY + y*dirX }
+	along := func(x, y float64) float64 { return x*dirX + y*dirY }
+	w, h := float64(width), float64(height)
+	minAcross := math.Min(math.Min(across(0, 0), across(w, 0)), math.Min(across(0, h), across(w, h)))
+	minAlong := math.Min(math.Min(along(0, 0), along(w, 0)), math.Min(along(0, h), along(w, h)))
+	maxAlong := math.Max(math.Max(along(0, 0), along(w, 0)), math.Max(along(0, h), along(w, h)))
+	length := math.Max(1, maxAlong-minAlong)
+
+	start, thickness := float64(opts.Position), float64(opts.Thickness)
+	period := opts.Period
+	if period <= 0 {
+		period = thickness
+	}
+	// Seed the noise from the slice so every slice tears differently, but
+	// the same slice always tears the same way
+	seed := uint32(opts.Position*7919 + opts.Thickness*104729 + opts.Offset*1299709)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			px, py := float64(x-bounds.Min.X)+.5, float64(y-bounds.Min.Y)+.5
+			v := across(px, py) - minAcross
+			u := along(px, py) - minAlong
+
+			lo, hi := start, start+thickness
+			switch opts.Shape {
+			case SliceWedge:
+				hi = start + thickness*u/length
+			case SliceJagged:
+				jag := thickness / 4
+				lo += jag * valueNoise(u/8, seed)
+				hi += jag * valueNoise(u/8, seed+1)
+			}
+			if v < lo || v >= hi {
+				continue
+			}
+
+			offset := float64(opts.Offset)
+			switch opts.Curve {
+			case CurveSine:
+				offset *= math.Sin(2 * math.Pi * (v - start) / period)
+			case CurveNoise:
+				offset *= valueNoise((v-start)/period, seed+2)
+			}
+
+			sx := wrapCoord(int(math.Floor(px+offset*dirX)), width) + bounds.Min.X
+			sy := wrapCoord(int(math.Floor(py+offset*dirY)), height) + bounds.Min.Y
+			blendPixel(destImage, x, y, sourceImage, sx, sy, mask, op)
+		}
+	}
+}
+
+// wrapCoord wraps v into the range 0 to size-1
+func wrapCoord(v, size int) int {
+	return (v%size + size) % size
+}
+
+// blendPixel draws the source pixel at sx, sy onto the destination pixel at
+// x, y through the mask value at sx, sy, as draw.DrawMask would
+func blendPixel(destImage *image.RGBA, x, y int, sourceImage *image.RGBA, sx, sy int, mask image.Image, op draw.Op) {
+	m := uint32(0xffff)
+	if mask != nil {
+		_, _, _, m = mask.At(sx, sy).RGBA()
+	}
+	if m == 0 && op == draw.Over {
+		return
+	}
+
+	d := destImage.PixOffset(x, y)
+	s := sourceImage.PixOffset(sx, sy)
+	srcA := uint32(sourceImage.Pix[s+3]) * 0x101 * m / 0xffff
+	for c := 0; c < 4; c++ {
+		src := uint32(sourceImage.Pix[s+c]) * 0x101 * m / 0xffff
+		dst := uint32(destImage.Pix[d+c]) * 0x101
+		var out uint32
+		if op == draw.Over {
+			out = src + dst*(0xffff-srcA)/0xffff
+		} else {
+			out = src + dst*(0xffff-m)/0xffff
+		}
+		destImage.Pix[d+c] = uint8(out >> 8)
+	}
+}
+
+// valueNoise is smooth 1D noise between -1 and 1, with a new random value at
+// each whole number of t
+func valueNoise(t float64, seed uint32) float64 {
+	i := math.Floor(t)
+	f := t - i
+	f = f * f * (3 - 2*f)
+	a, b := hashNoise(int(i), seed), hashNoise(int(i)+1, seed)
+	return a + (b-a)*f
+}
+
+// hashNoise returns a fixed pseudo random value between -1 and 1 for n
+func hashNoise(n int, seed uint32) float64 {
+	h := uint32(n)*0x27d4eb2d ^ seed*0x165667b1
+	h ^= h >> 15
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	return float64(h)/float64(math.MaxUint32)*2 - 1
+}
@@ -0,0 +1,215 @@
+package effects
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/darkliquid/glitch/utils"
+)
+
+// VHSOptions configures VHS. Zero values turn each artefact off.
+type VHSOptions struct {
+	// ChromaBlur is the width in pixels the colour is smeared over, as tape
+	// records colour at a much lower resolution than brightness
+	ChromaBlur int
+	// ChromaShift is how far in pixels the colour lags behind the brightness,
+	// fringing the right of every edge
+	ChromaShift int
+	// Ghost is the strength (0-1) of a faint copy of the brightness
+	// GhostOffset pixels to the right, like a reflection in the cable
+	Ghost       float64
+	GhostOffset int
+	// TrackingBands is how many bands of bad tracking to add. Rows in a band
+	// are pushed sideways by up to TrackingShift pixels, wobbling along it.
+	TrackingBands int
+	TrackingShift int
+	// HeadSwitch is how many rows at the bottom are skewed and noisy where
+	// the video heads switch over
+	HeadSwitch int
+	// Dropouts is the chance (0-1) of each row having a streak where the
+	// tape lost its signal
+	Dropouts float64
+	// Noise is the strength (0-1) of the snow over the whole picture
+	Noise float64
+}
+
+// VHS makes sourceImage look like it was played back from a worn out video
+// tape, writing the result to destImage, which may be sourceImage
+func VHS(rng utils.Rand, destImage, sourceImage *image.RGBA, opts VHSOptions) {
+	VHSContext(context.Background(), rng, destImage, sourceImage, opts)
+}
+
+// VHSContext is like VHS, but stops early and returns ctx.Err() if ctx is
+// cancelled, leaving the rest of the rows untouched
+func VHSContext(ctx context.Context, rng utils.Rand, destImage, sourceImage *image.RGBA, opts VHSOptions) error {
+	bounds := sourceImage.Bounds().Intersect(destImage.Bounds())
+	if bounds.Empty() {
+		return nil
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Split the picture into brightness and colour, like the tape does
+	lum := make([]float64, width*height)
+	inphase := make([]float64, width*height)
+	quadrature := make([]float64, width*height)
+	alpha := make([]uint8, width*height)
+	for y := 0; y < height; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := 0; x < width; x++ {
+			i := sourceImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			r, g, b := float64(sourceImage.Pix[i]), float64(sourceImage.Pix[i+1]), float64(sourceImage.Pix[i+2])
+			p := y*width + x
+			lum[p], inphase[p], quadrature[p] = rgbToYIQ(r, g, b)
+			alpha[p] = sourceImage.Pix[i+3]
+		}
+	}
+
+	// How far each row is pushed sideways, and how noisy it is
+	shift := make([]float64, height)
+	noise := make([]float64, height)
+	for i := range noise {
+		noise[i] = opts.Noise
+	}
+	for band := 0; band < opts.TrackingBands; band++ {
+		size := utils.Random(rng, height/16+1, height/4+2)
+		top := utils.Random(rng, -size/2, height)
+		amount := float64(utils.Random(rng, opts.TrackingShift/2, opts.TrackingShift+1))
+		period := float64(utils.Random(rng, 4, size+5))
+		phase := float64(rng.Float32()) * 2 * math.Pi
+		for y := top; y < top+size; y++ {
+			if y < 0 || y >= height {
+				continue
+			}
+			// Fade the band in and out so it wobbles rather than cuts
+			envelope := math.Sin(math.Pi * float64(y-top) / float64(size))
+			wobble := 0.6 + 0.4*math.Sin(2*math.Pi*float64(y)/period+phase)
+			jitter := (float64(rng.Float32()) - .5) * amount / 4
+			shift[y] += amount*envelope*wobble + jitter
+			noise[y] += 0.3 * envelope
+		}
+	}
+	if opts.HeadSwitch > 0 {
+		rows := opts.HeadSwitch
+		if rows > height {
+			rows = height
+		}
+		skew := float64(opts.TrackingShift)/2 + float64(rows)
+		for y := height - rows; y < height; y++ {
+			t := float64(y-(height-rows)+1) / float64(rows)
+			shift[y] += t*skew + (float64(rng.Float32())-.5)*skew/4
+			noise[y] += 0.4 * t
+		}
+	}
+
+	// sample reads a value from a row, repeating the edge pixels
+	sample := func(values []float64, row, x int) float64 {
+		return values[row+clampInt(x, 0, width-1)]
+	}
+
+	// Box blur the colour along each row, using running sums
+	blur := opts.ChromaBlur
+	if blur < 1 {
+		blur = 1
+	}
+	rowI := make([]float64, width+1)
+	rowQ := make([]float64, width+1)
+
+	for y := 0; y < height; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		row := y * width
+		for x := 0; x < width; x++ {
+			rowI[x+1] = rowI[x] + inphase[row+x]
+			rowQ[x+1] = rowQ[x] + quadrature[row+x]
+		}
+
+		// A dropout streak, brightest at its start and fading out
+		dropStart, dropEnd := -1, -1
+		if opts.Dropouts > 0 && float64(rng.Float32()) < opts.Dropouts {
+			dropStart = utils.Random(rng, 0, width)
+			dropEnd = dropStart + utils.Random(rng, width/32+1, width/4+2)
+		}
+
+		offset := int(math.Round(shift[y]))
+		for x := 0; x < width; x++ {
+			sx := x - offset
+			l := sample(lum, row, sx)
+			if opts.Ghost > 0 {
+				l = (1-opts.Ghost)*l + opts.Ghost*sample(lum, row, sx-opts.GhostOffset)
+			}
+
+			// The colour lags behind the brightness and is averaged over the blur
+			cx := sx - opts.ChromaShift
+			lo := clampInt(cx-blur/2, 0, width)
+			hi := clampInt(cx-blur/2+blur, 0, width)
+			var ci, cq float64
+			if hi > lo {
+				ci = (rowI[hi] - rowI[lo]) / float64(hi-lo)
+				cq = (rowQ[hi] - rowQ[lo]) / float64(hi-lo)
+			} else {
+				ci, cq = sample(inphase, row, cx), sample(quadrature, row, cx)
+			}
+
+			if n := noise[y]; n > 0 {
+				l += (float64(rng.Float32()) - .5) * 255 * n
+			}
+			if x >= dropStart && x < dropEnd {
+				fade := 1 - float64(x-dropStart)/float64(dropEnd-dropStart)
+				l += (255 - l) * fade * (0.5 + float64(rng.Float32())/2)
+				ci, cq = ci*(1-fade), cq*(1-fade)
+			}
+
+			r, g, b := yiqToRGB(l, ci, cq)
+			a := alpha[row+x]
+			i := destImage.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			// Keep the premultiplied colour valid
+			destImage.Pix[i] = clampChannel(r, a)
+			destImage.Pix[i+1] = clampChannel(g, a)
+			destImage.Pix[i+2] = clampChannel(b, a)
+			destImage.Pix[i+3] = a
+		}
+	}
+	return nil
+}
+
+// rgbToYIQ converts a colour to NTSC brightness and colour
+func rgbToYIQ(r, g, b float64) (y, i, q float64) {
+	y = 0.299*r + 0.587*g + 0.114*b
+	i = 0.596*r - 0.274*g - 0.322*b
+	q = 0.211*r - 0.523*g + 0.312*b
+	return y, i, q
+}
+
+// yiqToRGB converts NTSC brightness and colour back to a colour
+func yiqToRGB(y, i, q float64) (r, g, b float64) {
+	r = y + 0.956*i + 0.621*q
+	g = y - 0.272*i - 0.647*q
+	b = y - 1.106*i + 1.703*q
+	return r, g, b
+}
+
+// clampChannel rounds v to a channel value no greater than max
+func clampChannel(v float64, max uint8) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= float64(max) {
+		return max
+	}
+	return uint8(v + .5)
+}
+
+// clampInt limits v to between lo and hi
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -1,11 +1,13 @@
 package glitch
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"math"
+	"math/rand"
 
 	"github.com/darkliquid/glitch/dither"
 	"github.com/darkliquid/glitch/effects"
@@ -15,48 +17,237 @@ import (
 // Debug enables debugging print outs
 var Debug bool
 
-// The imageglitcher algorithm from airtight interactive
-func imageglitcher(inputData, outputData *image.RGBA, bounds image.Rectangle, glitchFactor float64) {
-	width, height := bounds.Max.X, bounds.Max.Y
+// planImageglitcher picks the random slices and channel used by imageglitcher
+func planImageglitcher(rng utils.Rand, bounds image.Rectangle, glitchFactor float64, trace *Trace) {
+	width, height := bounds.Dx(), bounds.Dy()
 	maxOffset := int(glitchFactor / 100.0 * float64(width))
-	mask := image.NewUniform(color.Alpha{A: 255})
 
 	// Random image slice offsetting
+	trace.Slices = trace.Slices[:0]
 	for i := 0.0; i < glitchFactor*2; i++ {
-		startY := utils.Random(0, height)
-		chunkHeight := int(math.Min(float64(height-startY), float64(utils.Random(1, height/4))))
-		offset := utils.Random(-maxOffset, maxOffset)
+		startY := utils.Random(rng, 0, height)
+		chunkHeight := int(math.Min(float64(height-startY), float64(utils.Random(rng, 1, height/4))))
+		offset := utils.Random(rng, -maxOffset, maxOffset)
+		trace.Slices = append(trace.Slices, TraceSlice{Y: startY, Height: chunkHeight, Offset: offset})
+	}
+
+	trace.Channel = utils.RandomChannel(rng)
+}
+
+// replayImageglitcher runs the imageglitcher algorithm using the choices in trace
+func replayImageglitcher(ctx context.Context, trace *Trace, inputData, outputData *image.RGBA) error {
+	mask := image.NewUniform(color.Alpha{A: 255})
 
-		effects.WrapSlice(outputData, inputData, offset, startY, chunkHeight, mask, draw.Src)
+	for _, s := range trace.Slices {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		shiftSlice(outputData, inputData, s, mask, draw.Src)
 	}
 
 	// Copy a random channel from the pristene original input data onto the slice-offsetted output data
-	effects.CopyChannel(outputData, inputData, utils.RandomChannel())
+	effects.CopyChannel(outputData, inputData, trace.Channel)
+	return nil
+}
+
+// The imageglitcher algorithm from airtight interactive
+func imageglitcher(ctx context.Context, rng utils.Rand, inputData, outputData *image.RGBA, bounds image.Rectangle, glitchFactor float64) error {
+	trace := &Trace{}
+	planImageglitcher(rng, bounds, glitchFactor, trace)
+	return replayImageglitcher(ctx, trace, inputData, outputData)
+}
+
+// planTear picks random slices like planImageglitcher, but torn vertically or
+// sheared diagonally as often as across, with wedged or jagged edges and
+// rippling offsets
+func planTear(rng utils.Rand, bounds image.Rectangle, glitchFactor float64, trace *Trace) {
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+
+	trace.Slices = trace.Slices[:0]
+	for i := 0.0; i < glitchFactor*2; i++ {
+		var angle float64
+		switch utils.Random(rng, 0, 3) {
+		case 1:
+			angle = 90
+		case 2:
+			angle = float64(utils.Random(rng, -60, 61))
+		}
+
+		// How far the image reaches across and along the slice
+		sin, cos := math.Abs(math.Sin(angle*math.Pi/180)), math.Abs(math.Cos(angle*math.Pi/180))
+		across := int(width*sin + height*cos)
+		along := int(width*cos + height*sin)
+		maxOffset := int(glitchFactor / 100.0 * float64(along))
+
+		slice := TraceSlice{
+			Y:      utils.Random(rng, 0, across),
+			Height: utils.Random(rng, 1, across/4),
+			Offset: utils.Random(rng, -maxOffset, maxOffset),
+			Angle:  angle,
+			Shape:  effects.SliceShape(utils.Random(rng, 0, 3)),
+			Curve:  effects.OffsetCurve(utils.Random(rng, 0, 3)),
+		}
+		if slice.Curve != effects.CurveUniform {
+			slice.Period = float64(utils.Random(rng, 4, across/2))
+		}
+		trace.Slices = append(trace.Slices, slice)
+	}
+
+	trace.Channel = utils.RandomChannel(rng)
+}
+
+// wtfSourceNames are the names of the intermediate buffers wtfify mixes
+// together. "input" and "output" are also valid in traces.
+var wtfSourceNames = []string{
+	"8bit",
+	"halftone",
+	"red",
+	"green",
+	"blue",
+	"original",
+}
+
+// wtfTransformNames are the names of the transforms wtfify picks between
+var wtfTransformNames = []string{
+	"atkinsons",
+	"8bit",
+	"bayer",
+	"halftone",
+	"floydsteinberg",
+	"wrapOver",
+	"wrapSrc",
+	"copyRed",
+	"copyGreen",
+	"copyBlue",
+	"copyAlpha",
+	"pixelSort",
+	"pixelate",
+	"channelShift",
+}
+
+// wtfTransformIndex looks up a transform by name
+func wtfTransformIndex(name string) int {
+	for i, n := range wtfTransformNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// planWtfSlices picks the random slices for one wtfify slice wrap
+func planWtfSlices(rng utils.Rand, bounds image.Rectangle, glitchFactor float64) []TraceSlice {
+	width, height := bounds.Dx(), bounds.Dy()
+	maxOffset := int(glitchFactor / 100.0 * float64(width))
+
+	// Random image slice offsetting
+	var slices []TraceSlice
+	for i := 0.0; i < glitchFactor; i++ {
+		startY := utils.Random(rng, 0, height)
+		chunkHeight := int(math.Min(float64(height-startY), float64(utils.Random(rng, 1, int(float64(height/2)*glitchFactor/100.0)))))
+		offset := utils.Random(rng, -maxOffset, maxOffset)
+		slices = append(slices, TraceSlice{Y: startY, Height: chunkHeight, Offset: offset})
+	}
+	return slices
 }
 
-func wtfify(inputData, outputData *image.RGBA, bounds image.Rectangle, glitchFactor float64) {
-	copyInput := image.NewRGBA(bounds)
-	copy(copyInput.Pix, inputData.Pix)
+// planWtfTransform picks the random parameters for a single wtfify transform
+func planWtfTransform(rng utils.Rand, bounds image.Rectangle, glitchFactor float64, index int, src, dest string) TraceTransform {
+	transform := TraceTransform{Index: index, Name: wtfTransformNames[index], Src: src, Dest: dest}
+	switch transform.Name {
+	case "atkinsons", "8bit", "halftone", "floydsteinberg":
+		transform.Threshold = utils.Random(rng, 64, 192)
+		transform.Slices = planWtfSlices(rng, bounds, glitchFactor)
+	case "bayer", "wrapOver", "wrapSrc":
+		transform.Slices = planWtfSlices(rng, bounds, glitchFactor)
+	case "pixelSort":
+		transform.Threshold = utils.Random(rng, 64, 192)
+		transform.Angle = float64(90 * utils.Random(rng, 0, 2))
+		transform.Slices = planWtfSlices(rng, bounds, glitchFactor)
+	case "pixelate":
+		transform.BlockWidth = utils.Random(rng, 2, 17)
+		transform.BlockHeight = utils.Random(rng, 2, 17)
+		transform.Sample = utils.Random(rng, 0, 2) == 1
+		if utils.Random(rng, 0, 2) == 1 {
+			transform.Levels = utils.Random(rng, 2, 9)
+		} else {
+			transform.Thresholds = []int{
+				utils.Random(rng, 64, 192),
+				utils.Random(rng, 64, 192),
+				utils.Random(rng, 64, 192),
+			}
+		}
+		transform.Slices = planWtfSlices(rng, bounds, glitchFactor)
+	case "channelShift":
+		shift := randomChannelShift(rng, bounds, glitchFactor)
+		for c := 0; c < 3; c++ {
+			transform.ChannelDX = append(transform.ChannelDX, shift.Offsets[c].X)
+			transform.ChannelDY = append(transform.ChannelDY, shift.Offsets[c].Y)
+			transform.Radial = append(transform.Radial, shift.Radial[c])
+		}
+		transform.Edge = shift.Edge
+		transform.Slices = planWtfSlices(rng, bounds, glitchFactor)
+	}
+	return transform
+}
+
+// planWtfify makes all the random choices for wtfify
+func planWtfify(rng utils.Rand, bounds image.Rectangle, glitchFactor float64) *Trace {
+	trace := &Trace{
+		Mode:         "wtf",
+		Width:        bounds.Dx(),
+		Height:       bounds.Dy(),
+		GlitchFactor: glitchFactor,
+		Thresholds: map[string]int{
+			"8bit":     utils.Random(rng, 0, 255),
+			"halftone": utils.Random(rng, 0, 255),
+		},
+	}
+
+	for i := len(wtfTransformNames); i > 0; i-- {
+		destIdx := utils.Random(rng, 0, len(wtfSourceNames))
+		srcIdx := utils.Random(rng, 0, len(wtfSourceNames))
+		fIdx := utils.Random(rng, 0, len(wtfTransformNames))
+		trace.Transforms = append(trace.Transforms, planWtfTransform(rng, bounds, glitchFactor, fIdx, wtfSourceNames[srcIdx], wtfSourceNames[destIdx]))
+
+		destIdx = utils.Random(rng, 0, len(wtfSourceNames))
+		fIdx = utils.Random(rng, 0, len(wtfTransformNames))
+		trace.Transforms = append(trace.Transforms, planWtfTransform(rng, bounds, glitchFactor, fIdx, "input", wtfSourceNames[destIdx]))
+	}
+
+	wrapOver := wtfTransformIndex("wrapOver")
+	for _, name := range wtfSourceNames {
+		trace.Transforms = append(trace.Transforms, TraceTransform{
+			Index:  wrapOver,
+			Name:   "wrapOver",
+			Src:    name,
+			Dest:   "output",
+			Slices: planWtfSlices(rng, bounds, glitchFactor),
+		})
+	}
+
+	planImageglitcher(rng, bounds, glitchFactor, trace)
 
-	eightBitted := image.NewRGBA(bounds)
-	copy(eightBitted.Pix, inputData.Pix)
-	dither.EightBit(eightBitted, utils.Random(0, 255))
+	return trace
+}
 
-	atkinsons := image.NewRGBA(bounds)
-	copy(atkinsons.Pix, inputData.Pix)
-	dither.Atkinsons(atkinsons, uint8(utils.Random(0, 255)))
+// replayWtfify runs the wtfify algorithm using the choices in trace
+func replayWtfify(ctx context.Context, trace *Trace, inputData, outputData *image.RGBA) error {
+	bounds := inputData.Bounds()
 
-	bayer := image.NewRGBA(bounds)
-	copy(bayer.Pix, inputData.Pix)
-	dither.Bayer(bayer)
+	copyInput := cloneRGBA(inputData)
 
-	halftone := image.NewRGBA(bounds)
-	copy(halftone.Pix, inputData.Pix)
-	dither.Halftone(halftone, uint16(utils.Random(0, 255)))
+	eightBitted := cloneRGBA(inputData)
+	dither.EightBit(eightBitted, trace.Thresholds["8bit"])
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	floydsteinberg := image.NewRGBA(bounds)
-	copy(floydsteinberg.Pix, inputData.Pix)
-	dither.FloydSteinberg(floydsteinberg, uint8(utils.Random(0, 255)))
+	halftone := cloneRGBA(inputData)
+	dither.Halftone(halftone, uint16(trace.Thresholds["halftone"]))
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	redOnly := image.NewRGBA(bounds)
 	effects.CopyChannel(redOnly, inputData, utils.Red)
@@ -68,132 +259,106 @@ func wtfify(inputData, outputData *image.RGBA, bounds image.Rectangle, glitchFac
 	effects.CopyChannel(blueOnly, inputData, utils.Blue)
 
 	alphaMask := image.NewAlpha(bounds)
-	for i := range alphaMask.Pix {
-		alphaMask.Pix[i] = inputData.Pix[i*4]
+	redToAlpha(alphaMask, inputData)
+
+	buffers := map[string]*image.RGBA{
+		"8bit":     eightBitted,
+		"halftone": halftone,
+		"red":      redOnly,
+		"green":    greenOnly,
+		"blue":     blueOnly,
+		"original": copyInput,
+		"input":    inputData,
+		"output":   outputData,
 	}
 
-	srcs := []*image.RGBA{
-		eightBitted,
-		halftone,
-		redOnly,
-		greenOnly,
-		blueOnly,
-		copyInput,
-	}
-	srcNames := []string{
-		"8bit",
-		"halftone",
-		"red",
-		"green",
-		"blue",
-		"original",
+	wrapSlice := func(in, out *image.RGBA, slices []TraceSlice, op draw.Op) {
+		for _, s := range slices {
+			shiftSlice(out, in, s, alphaMask, op)
+		}
 	}
 
-	wrapSlice := func(in, out *image.RGBA, op draw.Op) {
-		width, height := bounds.Max.X, bounds.Max.Y
-		maxOffset := int(glitchFactor / 100.0 * float64(width))
-
-		// Random image slice offsetting
-		for i := 0.0; i < glitchFactor; i++ {
-			startY := utils.Random(0, height)
-			chunkHeight := int(math.Min(float64(height-startY), float64(utils.Random(1, int(float64(height/2)*glitchFactor/100.0)))))
-			offset := utils.Random(-maxOffset, maxOffset)
-			effects.WrapSlice(out, in, offset, startY, chunkHeight, alphaMask, op)
-		}
+	// ditherWrap dithers a copy of in, uses it as the mask and wraps it onto out
+	ditherWrap := func(in, out *image.RGBA, t TraceTransform, ditherFunc func(*image.RGBA)) {
+		newIn := cloneRGBA(in)
+		ditherFunc(newIn)
+		redToAlpha(alphaMask, newIn)
+		wrapSlice(newIn, out, t.Slices, draw.Over)
 	}
 
-	transforms := []func(in, out *image.RGBA){
-		func(in, out *image.RGBA) {
-			newIn := image.NewRGBA(bounds)
-			copy(newIn.Pix, in.Pix)
-			dither.Atkinsons(newIn, uint8(utils.Random(64, 192)))
-			for i := range alphaMask.Pix {
-				alphaMask.Pix[i] = newIn.Pix[i*4]
-			}
-			wrapSlice(newIn, out, draw.Over)
+	transforms := []func(in, out *image.RGBA, t TraceTransform){
+		func(in, out *image.RGBA, t TraceTransform) {
+			ditherWrap(in, out, t, func(img *image.RGBA) { dither.BrokenAtkinsons(img, uint8(t.Threshold)) })
 		},
-		func(in, out *image.RGBA) {
-			newIn := image.NewRGBA(bounds)
-			copy(newIn.Pix, in.Pix)
-			dither.EightBit(newIn, utils.Random(64, 192))
-			for i := range alphaMask.Pix {
-				alphaMask.Pix[i] = newIn.Pix[i*4]
-			}
-			wrapSlice(newIn, out, draw.Over)
+		func(in, out *image.RGBA, t TraceTransform) {
+			ditherWrap(in, out, t, func(img *image.RGBA) { dither.EightBit(img, t.Threshold) })
 		},
-		func(in, out *image.RGBA) {
-			newIn := image.NewRGBA(bounds)
-			copy(newIn.Pix, in.Pix)
-			dither.Bayer(newIn)
-			for i := range alphaMask.Pix {
-				alphaMask.Pix[i] = newIn.Pix[i*4]
-			}
-			wrapSlice(newIn, out, draw.Over)
+		func(in, out *image.RGBA, t TraceTransform) {
+			ditherWrap(in, out, t, dither.Bayer)
 		},
-		func(in, out *image.RGBA) {
-			newIn := image.NewRGBA(bounds)
-			copy(newIn.Pix, in.Pix)
-			dither.Halftone(newIn, uint16(utils.Random(64, 192)))
-			for i := range alphaMask.Pix {
-				alphaMask.Pix[i] = newIn.Pix[i*4]
-			}
-			wrapSlice(newIn, out, draw.Over)
+		func(in, out *image.RGBA, t TraceTransform) {
+			ditherWrap(in, out, t, func(img *image.RGBA) { dither.Halftone(img, uint16(t.Threshold)) })
 		},
-		func(in, out *image.RGBA) {
-			newIn := image.NewRGBA(bounds)
-			copy(newIn.Pix, in.Pix)
-			dither.FloydSteinberg(newIn, uint8(utils.Random(64, 192)))
-			for i := range alphaMask.Pix {
-				alphaMask.Pix[i] = newIn.Pix[i*4]
-			}
-			wrapSlice(newIn, out, draw.Over)
+		func(in, out *image.RGBA, t TraceTransform) {
+			ditherWrap(in, out, t, func(img *image.RGBA) { dither.BrokenFloydSteinberg(img, uint8(t.Threshold)) })
 		},
-		func(in, out *image.RGBA) { wrapSlice(in, out, draw.Over) },
-		func(in, out *image.RGBA) { wrapSlice(in, out, draw.Src) },
-		func(in, out *image.RGBA) { effects.CopyChannel(out, in, utils.Red) },
-		func(in, out *image.RGBA) { effects.CopyChannel(out, in, utils.Green) },
-		func(in, out *image.RGBA) { effects.CopyChannel(out, in, utils.Blue) },
-		func(in, out *image.RGBA) {
-			for i := range alphaMask.Pix {
-				alphaMask.Pix[i] = in.Pix[i*4]
+		func(in, out *image.RGBA, t TraceTransform) { wrapSlice(in, out, t.Slices, draw.Over) },
+		func(in, out *image.RGBA, t TraceTransform) { wrapSlice(in, out, t.Slices, draw.Src) },
+		func(in, out *image.RGBA, t TraceTransform) { effects.CopyChannel(out, in, utils.Red) },
+		func(in, out *image.RGBA, t TraceTransform) { effects.CopyChannel(out, in, utils.Green) },
+		func(in, out *image.RGBA, t TraceTransform) { effects.CopyChannel(out, in, utils.Blue) },
+		func(in, out *image.RGBA, t TraceTransform) { redToAlpha(alphaMask, in) },
+		func(in, out *image.RGBA, t TraceTransform) {
+			newIn := cloneRGBA(in)
+			effects.PixelSort(newIn, effects.PixelSortOptions{
+				Angle: t.Angle,
+				Lower: float64(t.Threshold) / 255,
+				Upper: 1,
+			})
+			wrapSlice(newIn, out, t.Slices, draw.Over)
+		},
+		func(in, out *image.RGBA, t TraceTransform) {
+			ditherWrap(in, out, t, dither.Pixelate{
+				Width:      t.BlockWidth,
+				Height:     t.BlockHeight,
+				Sample:     t.Sample,
+				Levels:     t.Levels,
+				Thresholds: t.Thresholds,
+			}.Dither)
+		},
+		func(in, out *image.RGBA, t TraceTransform) {
+			shift := effects.ChannelShiftOptions{Edge: t.Edge}
+			for c := 0; c < 3; c++ {
+				if c < len(t.ChannelDX) && c < len(t.ChannelDY) {
+					shift.Offsets[c] = image.Pt(t.ChannelDX[c], t.ChannelDY[c])
+				}
+				if c < len(t.Radial) {
+					shift.Radial[c] = t.Radial[c]
+				}
 			}
+			newIn := image.NewRGBA(bounds)
+			effects.ChannelShift(newIn, in, shift)
+			wrapSlice(newIn, out, t.Slices, draw.Over)
 		},
 	}
-	transformNames := []string{
-		"atkinsons",
-		"8bit",
-		"bayer",
-		"halftone",
-		"floydsteinberg",
-		"wrapOver",
-		"wrapSrc",
-		"copyRed",
-		"copyGreen",
-		"copyBlue",
-		"copyAlpha",
-	}
-
-	i := len(transforms)
-	for i > 0 {
-		destIdx := utils.Random(0, len(srcs))
-		srcIdx := utils.Random(0, len(srcs))
-		fIdx := utils.Random(0, len(transforms))
-		transforms[fIdx](srcs[srcIdx], srcs[destIdx])
-		if Debug {
-			fmt.Printf("transform[%v] %v -> %v\n", transformNames[fIdx], srcNames[srcIdx], srcNames[destIdx])
+
+	for _, t := range trace.Transforms {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		destIdx = utils.Random(0, len(srcs))
-		fIdx = utils.Random(0, len(transforms))
-		transforms[fIdx](inputData, srcs[destIdx])
 
-		i--
-	}
+		if t.Index < 0 || t.Index >= len(transforms) || (t.Name != "" && t.Name != wtfTransformNames[t.Index]) {
+			return fmt.Errorf("glitch: trace has unknown transform %d %q", t.Index, t.Name)
+		}
+		src, dest := buffers[t.Src], buffers[t.Dest]
+		if src == nil || dest == nil || t.Src == "output" || t.Dest == "input" {
+			return fmt.Errorf("glitch: trace has bad transform buffers %q -> %q", t.Src, t.Dest)
+		}
 
-	for i, src := range srcs {
 		if Debug {
-			fmt.Printf("transform[wrapOver] %v -> output\n", srcNames[i])
+			fmt.Printf("transform[%v] %v -> %v\n", wtfTransformNames[t.Index], t.Src, t.Dest)
 		}
-		wrapSlice(src, outputData, draw.Over)
+		transforms[t.Index](src, dest, t)
 	}
 
 	if Debug {
@@ -203,18 +368,60 @@ func wtfify(inputData, outputData *image.RGBA, bounds image.Rectangle, glitchFac
 		alphaMask.Pix[i] = 255
 	}
 
-	finalOutput := image.NewRGBA(bounds)
-	copy(finalOutput.Pix, outputData.Pix)
+	finalOutput := cloneRGBA(outputData)
 	if Debug {
 		fmt.Println("imageglitcher for final output")
 	}
-	imageglitcher(finalOutput, outputData, bounds, glitchFactor)
+	return replayImageglitcher(ctx, trace, finalOutput, outputData)
 }
 
-// Glitchify returns the glitchified input image
-func Glitchify(inputDecode image.Image, glitchFactor, brightnessFactor float64, useScanLines bool) image.Image {
+// redToAlpha sets the mask to the red channel of img, which wtfify uses to
+// mask its slice wraps
+func redToAlpha(mask *image.Alpha, img *image.RGBA) {
+	bounds := mask.Bounds().Intersect(img.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			mask.Pix[mask.PixOffset(x, y)] = img.Pix[img.PixOffset(x, y)]
+		}
+	}
+}
+
+func wtfify(ctx context.Context, rng utils.Rand, inputData, outputData *image.RGBA, bounds image.Rectangle, glitchFactor float64) error {
+	return replayWtfify(ctx, planWtfify(rng, bounds, glitchFactor), inputData, outputData)
+}
+
+// Apply glitches the input image using the given options. It returns an error
+// if the options are invalid or if ctx is cancelled before glitching finishes.
+func Apply(ctx context.Context, inputDecode image.Image, opts Options) (image.Image, error) {
+	outputImg, _, err := apply(ctx, inputDecode, opts, false)
+	return outputImg, err
+}
+
+// Record is like Apply but also returns a trace of the random choices made,
+// which can be replayed onto other images with Options.Trace. The algorithm
+// for the configured mode must implement Tracer.
+func Record(ctx context.Context, inputDecode image.Image, opts Options) (image.Image, *Trace, error) {
+	return apply(ctx, inputDecode, opts, true)
+}
+
+func apply(ctx context.Context, inputDecode image.Image, opts Options, record bool) (image.Image, *Trace, error) {
+	if inputDecode == nil {
+		return nil, nil, ErrNilImage
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	algorithm, err := opts.algorithm()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
 	// Useful values
 	bounds := inputDecode.Bounds()
+	rng := opts.random()
 
 	// Initialise input as RGBA data
 	inputData := image.NewRGBA(bounds)
@@ -224,16 +431,85 @@ func Glitchify(inputDecode image.Image, glitchFactor, brightnessFactor float64,
 	outputData := image.NewRGBA(bounds)
 	draw.Draw(outputData, bounds, inputDecode, bounds.Min, draw.Src)
 
-	//imageglitcher(inputData, outputData, bounds, glitchFactor)
-	wtfify(inputData, outputData, bounds, glitchFactor)
+	var trace *Trace
+	if opts.Trace != nil || record {
+		tracer, ok := algorithm.(Tracer)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %q", ErrNotTraceable, opts.mode())
+		}
+		if opts.Trace != nil {
+			trace = opts.Trace.scaled(bounds)
+		} else {
+			trace = tracer.Plan(rng, bounds, opts.GlitchFactor)
+			trace.Mode = opts.mode()
+		}
+		err = tracer.Replay(ctx, trace, inputData, outputData)
+	} else {
+		err = algorithm.Glitch(ctx, rng, inputData, outputData, opts.GlitchFactor)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Pixelate it
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if opts.Pixelate != nil {
+		opts.Pixelate.Dither(outputData)
+	}
 
 	// Do brightness filter
-	effects.ApplyBrightness(outputData, brightnessFactor)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	effects.ApplyBrightness(outputData, opts.BrightnessFactor)
+
+	// Play it back from tape
+	if opts.VHS > 0 {
+		if err := effects.VHSContext(ctx, rng, outputData, outputData, VHSOptions(bounds, opts.VHS)); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	// Apply scanlines
-	if useScanLines {
+	// Apply scanlines, or a whole CRT
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if opts.CRT != nil {
+		if err := effects.CRTContext(ctx, outputData, outputData, *opts.CRT); err != nil {
+			return nil, nil, err
+		}
+	} else if opts.ScanLines && opts.ScanlineStyle != nil {
+		effects.Scanlines(outputData, *opts.ScanlineStyle)
+	} else if opts.ScanLines {
 		effects.ApplyScanlines(outputData)
 	}
 
-	return outputData
+	// Blend with the original outside the mask
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if opts.Mask != nil {
+		effects.ApplyMask(outputData, inputData, opts.Mask)
+	}
+
+	return outputData, trace, nil
+}
+
+// Glitchify returns the glitchified input image. It is kept for compatibility;
+// new code should use Apply, which validates its input and reports errors.
+// Out of range factors are clamped to 0-100.
+func Glitchify(inputDecode image.Image, glitchFactor, brightnessFactor float64, useScanLines bool) image.Image {
+	outputImg, err := Apply(context.Background(), inputDecode, Options{
+		GlitchFactor:     clampFactor(glitchFactor),
+		BrightnessFactor: clampFactor(brightnessFactor),
+		ScanLines:        useScanLines,
+		// Draw from the global source so callers seeding math/rand keep working
+		Rand: rand.New(rand.NewSource(rand.Int63())),
+	})
+	if err != nil {
+		return inputDecode
+	}
+	return outputImg
 }
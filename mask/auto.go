@@ -0,0 +1,236 @@
+package mask
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/darkliquid/glitch/utils"
+)
+
+// toRGBA returns img as an *image.RGBA, converting it if needed
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// fromPixels builds a mask over img's bounds, using opaque for each pixel
+// the function returns true for
+func fromPixels(img image.Image, opaque func(r, g, b uint8) bool) *image.Alpha {
+	rgba := toRGBA(img)
+	bounds := rgba.Bounds()
+	m := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := rgba.PixOffset(x, y)
+			if opaque(rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]) {
+				m.Pix[m.PixOffset(x, y)] = 0xff
+			}
+		}
+	}
+	return m
+}
+
+// Luminance returns a mask that is opaque where the brightness of img is
+// between lower and upper, from 0 to 1. Use it to target only highlights or
+// only shadows.
+func Luminance(img image.Image, lower, upper float64) *image.Alpha {
+	return fromPixels(img, func(r, g, b uint8) bool {
+		l := utils.Luminance(r, g, b)
+		return l >= lower && l <= upper
+	})
+}
+
+// Saturation returns a mask that is opaque where the saturation of img is at
+// least threshold, from 0 to 1
+func Saturation(img image.Image, threshold float64) *image.Alpha {
+	return fromPixels(img, func(r, g, b uint8) bool {
+		_, s, _ := utils.HSV(r, g, b)
+		return s >= threshold
+	})
+}
+
+// HueKey returns a mask that is opaque where the hue of img is within
+// tolerance of hue, all from 0 to 1 around the colour wheel. Grays have no
+// real hue, so pixels less saturated than minSaturation are never keyed.
+func HueKey(img image.Image, hue, tolerance, minSaturation float64) *image.Alpha {
+	return fromPixels(img, func(r, g, b uint8) bool {
+		h, s, _ := utils.HSV(r, g, b)
+		distance := math.Abs(h - hue)
+		distance = math.Min(distance, 1-distance)
+		return s >= minSaturation && distance <= tolerance
+	})
+}
+
+// ChromaKey returns a mask that is opaque where the colour of img is within
+// tolerance of key, comparing only the chroma (Cb and Cr) so the key works
+// in light and shade. Tolerance is from 0 to 1.
+func ChromaKey(img image.Image, key color.Color, tolerance float64) *image.Alpha {
+	keyR, keyG, keyB, _ := key.RGBA()
+	_, keyCb, keyCr := color.RGBToYCbCr(uint8(keyR>>8), uint8(keyG>>8), uint8(keyB>>8))
+	limit := tolerance * 255
+	return fromPixels(img, func(r, g, b uint8) bool {
+		_, cb, cr := color.RGBToYCbCr(r, g, b)
+		return math.Hypot(float64(cb)-float64(keyCb), float64(cr)-float64(keyCr)) <= limit
+	})
+}
+
+// gradients returns the Sobel gradient magnitude and direction of every pixel
+// of img's luminance, scaled so magnitudes are 0 to 1. If blur is set, the
+// luminance is smoothed first to reduce noise.
+func gradients(img image.Image, blur bool) (magnitude, direction []float64, width, height int) {
+	rgba := toRGBA(img)
+	bounds := rgba.Bounds()
+	width, height = bounds.Dx(), bounds.Dy()
+
+	lum := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := rgba.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			lum[y*width+x] = utils.Luminance(rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+		}
+	}
+
+	// at reads the luminance, repeating the edge pixels outside the image
+	at := func(values []float64, x, y int) float64 {
+		return values[clamp(y, 0, height-1)*width+clamp(x, 0, width-1)]
+	}
+
+	if blur {
+		// A 5x5 Gaussian, applied as two passes of 1 4 6 4 1
+		weights := []float64{1, 4, 6, 4, 1}
+		pass := func(values []float64, dx, dy int) []float64 {
+			out := make([]float64, len(values))
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					var sum float64
+					for k, w := range weights {
+						sum += w * at(values, x+(k-2)*dx, y+(k-2)*dy)
+					}
+					out[y*width+x] = sum / 16
+				}
+			}
+			return out
+		}
+		lum = pass(pass(lum, 1, 0), 0, 1)
+	}
+
+	magnitude = make([]float64, width*height)
+	direction = make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gx := at(lum, x+1, y-1) + 2*at(lum, x+1, y) + at(lum, x+1, y+1) -
+				at(lum, x-1, y-1) - 2*at(lum, x-1, y) - at(lum, x-1, y+1)
+			gy := at(lum, x-1, y+1) + 2*at(lum, x, y+1) + at(lum, x+1, y+1) -
+				at(lum, x-1, y-1) - 2*at(lum, x, y-1) - at(lum, x+1, y-1)
+			// The largest possible magnitude is 4 root 2
+			magnitude[y*width+x] = math.Hypot(gx, gy) / (4 * math.Sqrt2)
+			direction[y*width+x] = math.Atan2(gy, gx)
+		}
+	}
+	return magnitude, direction, width, height
+}
+
+// Sobel returns an edge mask of img. Pixels with an edge strength, from 0 to
+// 1, of at least threshold are opaque. A threshold of 0 gives the edge
+// strength itself as a soft mask.
+func Sobel(img image.Image, threshold float64) *image.Alpha {
+	magnitude, _, width, _ := gradients(img, false)
+	bounds := img.Bounds()
+	m := image.NewAlpha(bounds)
+	for p, v := range magnitude {
+		i := m.PixOffset(bounds.Min.X+p%width, bounds.Min.Y+p/width)
+		switch {
+		case threshold <= 0:
+			m.Pix[i] = level(v)
+		case v >= threshold:
+			m.Pix[i] = 0xff
+		}
+	}
+	return m
+}
+
+// Canny returns a thin, clean edge mask of img using the Canny edge detector.
+// Edges stronger than high are kept, as are edges stronger than low that are
+// connected to them. Both thresholds are from 0 to 1.
+func Canny(img image.Image, low, high float64) *image.Alpha {
+	magnitude, direction, width, height := gradients(img, true)
+
+	// Thin the edges, keeping only pixels stronger than their neighbours
+	// across the edge
+	thin := make([]float64, len(magnitude))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := y*width + x
+			// Round the gradient direction to one of four neighbour pairs
+			angle := math.Mod(direction[p]*180/math.Pi+180, 180)
+			var dx, dy int
+			switch {
+			case angle < 22.5 || angle >= 157.5:
+				dx, dy = 1, 0
+			case angle < 67.5:
+				dx, dy = 1, 1
+			case angle < 112.5:
+				dx, dy = 0, 1
+			default:
+				dx, dy = -1, 1
+			}
+			neighbour := func(nx, ny int) float64 {
+				if nx < 0 || ny < 0 || nx >= width || ny >= height {
+					return 0
+				}
+				return magnitude[ny*width+nx]
+			}
+			if magnitude[p] >= neighbour(x+dx, y+dy) && magnitude[p] >= neighbour(x-dx, y-dy) {
+				thin[p] = magnitude[p]
+			}
+		}
+	}
+
+	// Follow weak edges out from the strong ones
+	bounds := img.Bounds()
+	m := image.NewAlpha(bounds)
+	visited := make([]bool, len(thin))
+	var stack []int
+	for p, v := range thin {
+		if v >= high && !visited[p] {
+			visited[p] = true
+			stack = append(stack, p)
+		}
+	}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := p%width, p/width
+		m.Pix[m.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)] = 0xff
+		for ny := y - 1; ny <= y+1; ny++ {
+			for nx := x - 1; nx <= x+1; nx++ {
+				if nx < 0 || ny < 0 || nx >= width || ny >= height {
+					continue
+				}
+				if q := ny*width + nx; !visited[q] && thin[q] > 0 && thin[q] >= low {
+					visited[q] = true
+					stack = append(stack, q)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// clamp limits v to between lo and hi
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
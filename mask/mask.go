@@ -0,0 +1,258 @@
+package mask
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/darkliquid/glitch/utils"
+)
+
+// ErrBadShape is returned when parsing a shape that isn't understood
+var ErrBadShape = errors.New("mask: bad shape")
+
+// Rectangle returns a mask over bounds that is opaque inside r
+func Rectangle(bounds, r image.Rectangle) *image.Alpha {
+	m := image.NewAlpha(bounds)
+	draw.Draw(m, r, image.Opaque, image.Point{}, draw.Src)
+	return m
+}
+
+// Ellipse returns a mask over bounds that is opaque inside the ellipse that
+// fits r, with an anti-aliased edge
+func Ellipse(bounds, r image.Rectangle) *image.Alpha {
+	m := image.NewAlpha(bounds)
+	if r.Empty() {
+		return m
+	}
+	cx, cy := float64(r.Min.X+r.Max.X)/2, float64(r.Min.Y+r.Max.Y)/2
+	rx, ry := float64(r.Dx())/2, float64(r.Dy())/2
+	area := r.Intersect(bounds)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			dx, dy := (float64(x)+.5-cx)/rx, (float64(y)+.5-cy)/ry
+			// Distance outside the edge, roughly in pixels
+			edge := (math.Sqrt(dx*dx+dy*dy) - 1) * math.Min(rx, ry)
+			m.Pix[m.PixOffset(x, y)] = level(.5 - edge)
+		}
+	}
+	return m
+}
+
+// LinearGradient returns a mask over bounds that fades from transparent at
+// from to opaque at to
+func LinearGradient(bounds image.Rectangle, from, to image.Point) *image.Alpha {
+	m := image.NewAlpha(bounds)
+	dx, dy := float64(to.X-from.X), float64(to.Y-from.Y)
+	length := dx*dx + dy*dy
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			t := 1.0
+			if length > 0 {
+				t = ((float64(x-from.X)+.5)*dx + (float64(y-from.Y)+.5)*dy) / length
+			}
+			m.Pix[m.PixOffset(x, y)] = level(t)
+		}
+	}
+	return m
+}
+
+// RadialGradient returns a mask over bounds that is opaque at centre and
+// fades to transparent radius pixels away
+func RadialGradient(bounds image.Rectangle, centre image.Point, radius float64) *image.Alpha {
+	m := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			t := 0.0
+			if radius > 0 {
+				t = 1 - math.Hypot(float64(x-centre.X)+.5, float64(y-centre.Y)+.5)/radius
+			}
+			m.Pix[m.PixOffset(x, y)] = level(t)
+		}
+	}
+	return m
+}
+
+// Invert flips a mask in place, so opaque becomes transparent
+func Invert(m *image.Alpha) *image.Alpha {
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := m.PixOffset(x, y)
+			m.Pix[i] = 0xff - m.Pix[i]
+		}
+	}
+	return m
+}
+
+// Parse builds a mask over bounds from a shape description of the form
+// "shape:a,b,..." where the shape is one of:
+//
+//	rect:x0,y0,x1,y1      opaque inside the rectangle
+//	ellipse:x0,y0,x1,y1   opaque inside the ellipse that fits the rectangle
+//	gradient:x0,y0,x1,y1  fades from transparent at x0,y0 to opaque at x1,y1
+//	radial:x,y,r          opaque at x,y, fading to transparent r away
+//
+// Coordinates are fractions of the width and height of bounds, and the radius
+// is a fraction of the width. A leading "!" inverts the mask.
+func Parse(spec string, bounds image.Rectangle) (*image.Alpha, error) {
+	return parse(spec, bounds, nil)
+}
+
+// ParseImage is like Parse, but also understands masks generated from img:
+//
+//	luminance:lower,upper     opaque where the brightness is in range
+//	saturation:threshold      opaque where the saturation is at least threshold
+//	hue:hue,tolerance[,min]   opaque near hue, ignoring colours less saturated than min
+//	chroma:RRGGBB,tolerance   opaque near the chroma of the hex colour
+//	sobel:threshold           opaque on edges, or soft edges if threshold is 0
+//	canny:low,high            opaque on thin, connected edges
+//
+// All values are from 0 to 1. Shapes are fitted to the bounds of img.
+func ParseImage(spec string, img image.Image) (*image.Alpha, error) {
+	return parse(spec, img.Bounds(), img)
+}
+
+// IsSpec reports whether spec starts with the name of one of the shapes or
+// generated masks ParseImage understands, rather than being a file name
+func IsSpec(spec string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(spec, "!"), ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	switch strings.ToLower(parts[0]) {
+	case "rect", "ellipse", "gradient", "radial",
+		"luminance", "saturation", "hue", "chroma", "sobel", "canny":
+		return true
+	}
+	return false
+}
+
+// parse builds the mask for a spec, only allowing the generated masks if img
+// isn't nil
+func parse(spec string, bounds image.Rectangle, img image.Image) (*image.Alpha, error) {
+	invert := strings.HasPrefix(spec, "!")
+	spec = strings.TrimPrefix(spec, "!")
+
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: %q", ErrBadShape, spec)
+	}
+	name := strings.ToLower(parts[0])
+	fields := strings.Split(parts[1], ",")
+
+	// A chroma key starts with a colour rather than a number
+	var key color.RGBA
+	if name == "chroma" && img != nil {
+		var err error
+		if key, err = utils.ParseHexColor(fields[0]); err != nil {
+			return nil, fmt.Errorf("%w: chroma key: %v", ErrBadShape, err)
+		}
+		fields = fields[1:]
+	}
+
+	var values []float64
+	for _, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadShape, spec)
+		}
+		values = append(values, v)
+	}
+	need := func(counts ...int) error {
+		for _, n := range counts {
+			if len(values) == n {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s needs %d values", ErrBadShape, name, counts[0])
+	}
+
+	point := func(fx, fy float64) image.Point {
+		return image.Pt(
+			bounds.Min.X+int(math.Round(fx*float64(bounds.Dx()))),
+			bounds.Min.Y+int(math.Round(fy*float64(bounds.Dy()))),
+		)
+	}
+
+	var m *image.Alpha
+	var err error
+	switch {
+	case name == "rect" || name == "ellipse" || name == "gradient":
+		if err = need(4); err != nil {
+			break
+		}
+		from, to := point(values[0], values[1]), point(values[2], values[3])
+		switch name {
+		case "rect":
+			m = Rectangle(bounds, image.Rect(from.X, from.Y, to.X, to.Y))
+		case "ellipse":
+			m = Ellipse(bounds, image.Rect(from.X, from.Y, to.X, to.Y))
+		default:
+			m = LinearGradient(bounds, from, to)
+		}
+	case name == "radial":
+		if err = need(3); err == nil {
+			m = RadialGradient(bounds, point(values[0], values[1]), values[2]*float64(bounds.Dx()))
+		}
+	case img == nil:
+		err = fmt.Errorf("%w: %q", ErrBadShape, spec)
+	case name == "luminance":
+		if err = need(2); err == nil {
+			m = Luminance(img, values[0], values[1])
+		}
+	case name == "saturation":
+		if err = need(1); err == nil {
+			m = Saturation(img, values[0])
+		}
+	case name == "hue":
+		if err = need(2, 3); err == nil {
+			minSaturation := defaultHueSaturation
+			if len(values) == 3 {
+				minSaturation = values[2]
+			}
+			m = HueKey(img, values[0], values[1], minSaturation)
+		}
+	case name == "chroma":
+		if err = need(1); err == nil {
+			m = ChromaKey(img, key, values[0])
+		}
+	case name == "sobel":
+		if err = need(1); err == nil {
+			m = Sobel(img, values[0])
+		}
+	case name == "canny":
+		if err = need(2); err == nil {
+			m = Canny(img, values[0], values[1])
+		}
+	default:
+		err = fmt.Errorf("%w: %q", ErrBadShape, spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if invert {
+		Invert(m)
+	}
+	return m, nil
+}
+
+// defaultHueSaturation is the least saturation a hue key matches by default
+const defaultHueSaturation = 0.2
+
+// level converts a coverage from 0 to 1 into an alpha value, clamping it
+func level(t float64) uint8 {
+	if t <= 0 {
+		return 0
+	}
+	if t >= 1 {
+		return 0xff
+	}
+	return uint8(t*0xff + .5)
+}
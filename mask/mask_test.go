@@ -0,0 +1,28 @@
+package mask
+
+import "testing"
+
+func TestIsSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want bool
+	}{
+		{"rect:0,0,10,10", true},
+		{"!ellipse:0.5,0.5,0.25,0.25", true},
+		{"GRADIENT:0,0,1,1", true},
+		{"chroma:00ff00,40", true},
+		{"sobel:64", true},
+		{"rect", false},
+		{"mask.png", false},
+		{"out:v2.png", false},
+		{`C:\masks\m.png`, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if got := IsSpec(tt.spec); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
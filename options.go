@@ -0,0 +1,152 @@
+package glitch
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+
+	"github.com/darkliquid/glitch/dither"
+	"github.com/darkliquid/glitch/effects"
+	"github.com/darkliquid/glitch/utils"
+)
+
+var (
+	// ErrGlitchFactor is returned when the glitch factor is out of range
+	ErrGlitchFactor = errors.New("glitch: glitch factor must be between 0 and 100")
+	// ErrBrightnessFactor is returned when the brightness factor is out of range
+	ErrBrightnessFactor = errors.New("glitch: brightness factor must be between 0 and 100")
+	// ErrVHSFactor is returned when the VHS intensity is out of range
+	ErrVHSFactor = errors.New("glitch: vhs intensity must be between 0 and 100")
+	// ErrScanlineStyle is returned when the scan line style is out of range
+	ErrScanlineStyle = errors.New("glitch: scan line period must be at least 1 and opacity between 0 and 1")
+	// ErrPixelate is returned when the pixelate settings are out of range
+	ErrPixelate = errors.New("glitch: pixelate blocks must be 0-256 pixels, levels 0-256 and thresholds 0-255")
+	// ErrNilImage is returned when no input image is given
+	ErrNilImage = errors.New("glitch: input image is nil")
+)
+
+// Options configures how an image is glitched
+type Options struct {
+	// GlitchFactor defines how much glitching to do (0-100)
+	GlitchFactor float64
+	// BrightnessFactor defines how much brightening to do (0-100)
+	BrightnessFactor float64
+	// ScanLines applies the scan line filter
+	ScanLines bool
+	// ScanlineStyle sets the pattern the scan line filter draws when
+	// ScanLines is set. If it is nil, every other row is blacked out.
+	ScanlineStyle *effects.ScanlineOptions
+	// CRT shows the result on a CRT when it isn't nil, in place of the
+	// ScanLines filter. effects.DefaultCRT has typical settings.
+	CRT *effects.CRTOptions
+	// Pixelate pixelates the glitch into blocks when it isn't nil
+	Pixelate *dither.Pixelate
+	// VHS is how worn out a video tape to play the glitch back from (0-100).
+	// It is off when 0.
+	VHS float64
+	// Mode is the name of the registered algorithm to glitch with.
+	// It defaults to DefaultMode when empty.
+	Mode string
+	// Pipeline runs the steps of a recipe in place of Mode. Pipelines can't
+	// be traced.
+	Pipeline *Pipeline
+	// Seed seeds the random source when Rand is nil, so the same seed
+	// always produces the same glitch
+	Seed int64
+	// Rand is the random source to use. It takes precedence over Seed and
+	// must not be shared between concurrent calls.
+	Rand utils.Rand
+	// Trace replays a previously recorded trace instead of making new random
+	// choices. The trace's mode is used in place of Mode.
+	Trace *Trace
+	// Mask limits where the glitch is applied, blending the result with the
+	// original image. White or opaque parts of the mask are fully glitched
+	// and black or transparent parts are left alone. It is stretched to fit
+	// the image. The package mask has masks made from shapes and gradients.
+	Mask image.Image
+}
+
+// DefaultOptions returns the options used by the command line tool by default
+func DefaultOptions() Options {
+	return Options{
+		GlitchFactor:     5.0,
+		BrightnessFactor: 5.0,
+		ScanLines:        true,
+		Mode:             DefaultMode,
+	}
+}
+
+// Validate checks the options are within their allowed ranges
+func (o Options) Validate() error {
+	if !(o.GlitchFactor >= 0.0 && o.GlitchFactor <= 100.0) {
+		return ErrGlitchFactor
+	}
+	if !(o.BrightnessFactor >= 0.0 && o.BrightnessFactor <= 100.0) {
+		return ErrBrightnessFactor
+	}
+	if !(o.VHS >= 0.0 && o.VHS <= 100.0) {
+		return ErrVHSFactor
+	}
+	if s := o.ScanlineStyle; s != nil && (s.Period < 1 || !(s.Opacity >= 0.0 && s.Opacity <= 1.0)) {
+		return ErrScanlineStyle
+	}
+	if p := o.Pixelate; p != nil {
+		valid := p.Size >= 0 && p.Size <= 256 && p.Width >= 0 && p.Width <= 256 &&
+			p.Height >= 0 && p.Height <= 256 && p.Levels >= 0 && p.Levels <= 256 &&
+			p.Threshold >= 0 && p.Threshold <= 255 && len(p.Thresholds) <= 3
+		for _, t := range p.Thresholds {
+			valid = valid && t >= 0 && t <= 255
+		}
+		if !valid {
+			return ErrPixelate
+		}
+	}
+	if _, err := o.algorithm(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// random returns the random source to use for a glitch run
+func (o Options) random() utils.Rand {
+	if o.Rand != nil {
+		return o.Rand
+	}
+	return rand.New(rand.NewSource(o.Seed))
+}
+
+// mode returns the name of the algorithm to glitch with
+func (o Options) mode() string {
+	switch {
+	case o.Pipeline != nil:
+		return "recipe"
+	case o.Trace != nil && o.Trace.Mode != "":
+		return o.Trace.Mode
+	case o.Mode != "":
+		return o.Mode
+	}
+	return DefaultMode
+}
+
+// algorithm returns the registered algorithm for the configured mode
+func (o Options) algorithm() (Algorithm, error) {
+	if o.Pipeline != nil {
+		return o.Pipeline, nil
+	}
+	mode := o.mode()
+	algorithm, ok := Lookup(mode)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMode, mode)
+	}
+	return algorithm, nil
+}
+
+// clampFactor forces a factor into the 0-100 range
+func clampFactor(factor float64) float64 {
+	if math.IsNaN(factor) {
+		return 0
+	}
+	return math.Max(0, math.Min(100, factor))
+}
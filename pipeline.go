@@ -0,0 +1,720 @@
+package glitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/darkliquid/glitch/dither"
+	"github.com/darkliquid/glitch/effects"
+	"github.com/darkliquid/glitch/mask"
+	"github.com/darkliquid/glitch/utils"
+)
+
+// ErrUnknownStep is returned when a recipe names a step that doesn't exist
+var ErrUnknownStep = errors.New("glitch: unknown pipeline step")
+
+// Step is a single stage of a Pipeline. In a recipe it is written as a
+// string such as "dither floydsteinberg threshold=128", where the first
+// word is the name, bare words are positional arguments and key=value
+// words are named parameters.
+type Step struct {
+	Name   string
+	Args   []string
+	Params map[string]string
+}
+
+// ParseStep parses the textual form of a step
+func ParseStep(text string) (Step, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return Step{}, errors.New("glitch: empty pipeline step")
+	}
+
+	step := Step{Name: strings.ToLower(fields[0])}
+	for _, field := range fields[1:] {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			if step.Params == nil {
+				step.Params = make(map[string]string)
+			}
+			step.Params[strings.ToLower(key)] = value
+			continue
+		}
+		step.Args = append(step.Args, field)
+	}
+	return step, nil
+}
+
+// String returns the textual form of the step
+func (s Step) String() string {
+	words := append([]string{s.Name}, s.Args...)
+	keys := make([]string, 0, len(s.Params))
+	for key := range s.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		words = append(words, key+"="+s.Params[key])
+	}
+	return strings.Join(words, " ")
+}
+
+// MarshalJSON encodes the step in its textual form
+func (s Step) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON decodes the step from its textual form
+func (s *Step) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	step, err := ParseStep(text)
+	if err != nil {
+		return err
+	}
+	*s = step
+	return nil
+}
+
+// arg returns the positional argument at i, or def if there isn't one
+func (s Step) arg(i int, def string) string {
+	if i < len(s.Args) {
+		return s.Args[i]
+	}
+	return def
+}
+
+// float returns the named parameter as a float, falling back to the
+// positional argument at i and then to def
+func (s Step) float(name string, i int, def float64) (float64, error) {
+	value, ok := s.Params[name]
+	if !ok {
+		if i < 0 || i >= len(s.Args) {
+			return def, nil
+		}
+		value = s.Args[i]
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("glitch: step %q: bad %s %q", s.Name, name, value)
+	}
+	return f, nil
+}
+
+// factor is like float but also checks the value is within 0-100
+func (s Step) factor(name string, i int, def float64) (float64, error) {
+	f, err := s.float(name, i, def)
+	if err != nil {
+		return 0, err
+	}
+	if !(f >= 0.0 && f <= 100.0) {
+		return 0, fmt.Errorf("glitch: step %q: %s must be between 0 and 100", s.Name, name)
+	}
+	return f, nil
+}
+
+// stepRunner applies a compiled step to current. original is the untouched
+// pipeline input, for steps that pull data back from it.
+type stepRunner func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error
+
+// stepBuilders compiles the steps that can be used in a recipe
+var stepBuilders = map[string]func(s Step) (stepRunner, error){
+	"glitch":       buildGlitchStep,
+	"wrapslice":    buildWrapSliceStep,
+	"copychannel":  buildCopyChannelStep,
+	"dither":       buildDitherStep,
+	"brightness":   buildBrightnessStep,
+	"scanlines":    buildScanlinesStep,
+	"vhs":          buildVHSStep,
+	"crt":          buildCRTStep,
+	"pixelsort":    buildPixelSortStep,
+	"channelshift": buildChannelShiftStep,
+}
+
+// Pipeline is an ordered list of steps, usually loaded from a JSON recipe:
+//
+//	{"steps": ["wrapslice", "copychannel red", "dither floydsteinberg threshold=128", "scanlines", "brightness 10"]}
+//
+// Loaded pipelines are compiled once, so palettes and matrices are only
+// built when loading. A Pipeline made by hand is compiled every time it runs.
+type Pipeline struct {
+	Steps []Step `json:"steps"`
+
+	// runners are the compiled steps, if the pipeline was loaded
+	runners []stepRunner
+}
+
+// LoadPipeline reads a JSON recipe and checks all its steps are valid
+func LoadPipeline(r io.Reader) (*Pipeline, error) {
+	var p Pipeline
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, fmt.Errorf("glitch: couldn't decode recipe: %w", err)
+	}
+	runners, err := p.compile()
+	if err != nil {
+		return nil, err
+	}
+	p.runners = runners
+	return &p, nil
+}
+
+// LoadPipelineFile reads a JSON recipe from the named file
+func LoadPipelineFile(name string) (*Pipeline, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadPipeline(f)
+}
+
+// compile builds the runners for each step, checking they are all valid
+func (p *Pipeline) compile() ([]stepRunner, error) {
+	runners := make([]stepRunner, 0, len(p.Steps))
+	for _, step := range p.Steps {
+		build, ok := stepBuilders[step.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownStep, step.Name)
+		}
+		runner, err := build(step)
+		if err != nil {
+			return nil, err
+		}
+		if spec, ok := step.Params["mask"]; ok {
+			if runner, err = maskedStep(step, spec, runner); err != nil {
+				return nil, err
+			}
+		}
+		runners = append(runners, runner)
+	}
+	return runners, nil
+}
+
+// maskedStep limits a step to a mask, which is built from the image as it is
+// just before the step runs. Any step can be masked with mask=<spec>, where
+// spec is anything mask.ParseImage understands.
+func maskedStep(s Step, spec string, run stepRunner) (stepRunner, error) {
+	if _, err := mask.ParseImage(spec, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		return nil, fmt.Errorf("glitch: step %q: %w", s.Name, err)
+	}
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		m, err := mask.ParseImage(spec, current)
+		if err != nil {
+			return err
+		}
+		before := cloneRGBA(current)
+		if err := run(ctx, rng, original, current); err != nil {
+			return err
+		}
+		effects.ApplyMask(current, before, m)
+		return nil
+	}, nil
+}
+
+// Run applies each step of the pipeline in order to a copy of the input
+// image. All randomness comes from rng; if it is nil a source seeded with 0
+// is used, so a pipeline is deterministic by default. Use Options.Pipeline
+// with Apply to also brighten, mask and add scan lines afterwards.
+func (p *Pipeline) Run(ctx context.Context, inputDecode image.Image, rng utils.Rand) (image.Image, error) {
+	if inputDecode == nil {
+		return nil, ErrNilImage
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewSource(0))
+	}
+
+	bounds := inputDecode.Bounds()
+
+	original := image.NewRGBA(bounds)
+	draw.Draw(original, bounds, inputDecode, bounds.Min, draw.Src)
+
+	current := cloneRGBA(original)
+	if err := p.Glitch(ctx, rng, original, current, 0); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// Glitch runs the steps of the pipeline on output, so a pipeline can be used
+// as an Algorithm. The glitch factor is ignored, as each step has its own.
+func (p *Pipeline) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	runners := p.runners
+	if runners == nil {
+		var err error
+		if runners, err = p.compile(); err != nil {
+			return err
+		}
+	}
+	for _, run := range runners {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := run(ctx, rng, input, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneRGBA returns a copy of img with the same bounds. The copy has its own
+// tightly packed pixels, even if img is a sub image.
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	bounds := img.Bounds()
+	clone := image.NewRGBA(bounds)
+	rowBytes := 4 * bounds.Dx()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := img.PixOffset(bounds.Min.X, y)
+		copy(clone.Pix[clone.PixOffset(bounds.Min.X, y):], img.Pix[i:i+rowBytes])
+	}
+	return clone
+}
+
+// glitch [mode] [factor=N]
+func buildGlitchStep(s Step) (stepRunner, error) {
+	opts := Options{Mode: s.arg(0, DefaultMode)}
+	algorithm, err := opts.algorithm()
+	if err != nil {
+		return nil, err
+	}
+	glitchFactor, err := s.factor("factor", 1, 5.0)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		return algorithm.Glitch(ctx, rng, cloneRGBA(current), current, glitchFactor)
+	}, nil
+}
+
+// wrapslice [factor=N] [op=src|over] [angle=D] [shape=straight|wedge|jagged]
+// [curve=uniform|sine|noise] [period=N]
+func buildWrapSliceStep(s Step) (stepRunner, error) {
+	glitchFactor, err := s.factor("factor", 0, 5.0)
+	if err != nil {
+		return nil, err
+	}
+	var op draw.Op
+	switch s.Params["op"] {
+	case "", "src":
+		op = draw.Src
+	case "over":
+		op = draw.Over
+	default:
+		return nil, fmt.Errorf("glitch: step %q: unknown op %q", s.Name, s.Params["op"])
+	}
+	angle, err := s.float("angle", -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	period, err := s.float("period", -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	var shape effects.SliceShape
+	if name, ok := s.Params["shape"]; ok {
+		if shape, ok = effects.SliceShapes[strings.ToLower(name)]; !ok {
+			return nil, fmt.Errorf("glitch: step %q: unknown shape %q", s.Name, name)
+		}
+	}
+	var curve effects.OffsetCurve
+	if name, ok := s.Params["curve"]; ok {
+		if curve, ok = effects.OffsetCurves[strings.ToLower(name)]; !ok {
+			return nil, fmt.Errorf("glitch: step %q: unknown curve %q", s.Name, name)
+		}
+	}
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		bounds := current.Bounds()
+		opaque := image.Opaque
+		source := cloneRGBA(current)
+
+		// How far the image reaches across and along the slices
+		sin, cos := math.Abs(math.Sin(angle*math.Pi/180)), math.Abs(math.Cos(angle*math.Pi/180))
+		across := int(float64(bounds.Dx())*sin + float64(bounds.Dy())*cos)
+		along := int(float64(bounds.Dx())*cos + float64(bounds.Dy())*sin)
+		maxOffset := int(glitchFactor / 100.0 * float64(along))
+
+		// Random image slice offsetting
+		for i := 0.0; i < glitchFactor*2; i++ {
+			startY := utils.Random(rng, 0, across)
+			chunkHeight := int(math.Min(float64(across-startY), float64(utils.Random(rng, 1, across/4))))
+			offset := utils.Random(rng, -maxOffset, maxOffset)
+			shiftSlice(current, source, TraceSlice{
+				Y:      startY,
+				Height: chunkHeight,
+				Offset: offset,
+				Angle:  angle,
+				Shape:  shape,
+				Curve:  curve,
+				Period: period,
+			}, opaque, op)
+		}
+		return nil
+	}, nil
+}
+
+// copychannel red|green|blue|alpha|random
+func buildCopyChannelStep(s Step) (stepRunner, error) {
+	name := strings.ToLower(s.arg(0, "random"))
+	if name == "random" {
+		return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+			effects.CopyChannel(current, original, utils.RandomChannel(rng))
+			return nil
+		}, nil
+	}
+
+	var channel utils.Channel
+	switch name {
+	case "red":
+		channel = utils.Red
+	case "green":
+		channel = utils.Green
+	case "blue":
+		channel = utils.Blue
+	case "alpha":
+		channel = utils.Alpha
+	default:
+		return nil, fmt.Errorf("glitch: step %q: unknown channel %q", s.Name, name)
+	}
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		effects.CopyChannel(current, original, channel)
+		return nil
+	}, nil
+}
+
+// dither eightbit|halftone|screen|<matrix>|<kernel> [threshold=N] [palette=P] [size=N] [perchannel=true]
+// [serpentine=true] [broken=true] [shape=S] [inks=I] [angles=A,B,...]
+// [width=N] [height=N] [sample=true] [levels=N] [thresholds=R,G,B]
+// where <matrix> is any of the ordered dither matrices in dither.Matrices,
+// <kernel> is any of the error diffusion kernels in dither.Kernels, P is one
+// of dither.Palettes or a palette file, S is one of dither.DotShapes and I is
+// one of dither.Separations
+func buildDitherStep(s Step) (stepRunner, error) {
+	threshold, err := s.float("threshold", 1, 128)
+	if err != nil {
+		return nil, err
+	}
+	if threshold < 0 || threshold > 255 {
+		return nil, fmt.Errorf("glitch: step %q: threshold must be between 0 and 255", s.Name)
+	}
+	size, err := s.float("size", -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || size > 64 {
+		return nil, fmt.Errorf("glitch: step %q: size must be between 0 and 64", s.Name)
+	}
+
+	var palette color.Palette
+	if name, ok := s.Params["palette"]; ok {
+		if palette, ok = dither.Palettes[strings.ToLower(name)]; !ok {
+			if palette, err = dither.LoadPaletteFile(name); err != nil {
+				return nil, fmt.Errorf("glitch: step %q: couldn't load palette: %w", s.Name, err)
+			}
+		}
+	}
+
+	var apply func(img *image.RGBA)
+	switch name := strings.ToLower(s.arg(0, "")); name {
+	case "eightbit", "8bit", "pixelate":
+		pixelate := dither.Pixelate{Size: int(size), Threshold: int(threshold), Palette: palette, Sample: s.Params["sample"] == "true"}
+		width, err := s.float("width", -1, 0)
+		if err != nil {
+			return nil, err
+		}
+		height, err := s.float("height", -1, 0)
+		if err != nil {
+			return nil, err
+		}
+		levels, err := s.float("levels", -1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if width < 0 || width > 256 || height < 0 || height > 256 {
+			return nil, fmt.Errorf("glitch: step %q: width and height must be between 0 and 256", s.Name)
+		}
+		if levels < 0 || levels > 256 {
+			return nil, fmt.Errorf("glitch: step %q: levels must be between 0 and 256", s.Name)
+		}
+		pixelate.Width, pixelate.Height, pixelate.Levels = int(width), int(height), int(levels)
+		if thresholds, ok := s.Params["thresholds"]; ok {
+			for _, field := range strings.Split(thresholds, ",") {
+				t, err := strconv.Atoi(strings.TrimSpace(field))
+				if err != nil || t < 0 || t > 255 {
+					return nil, fmt.Errorf("glitch: step %q: bad threshold %q", s.Name, field)
+				}
+				pixelate.Thresholds = append(pixelate.Thresholds, t)
+			}
+		}
+		apply = pixelate.Dither
+	case "halftone":
+		apply = dither.Halftoner{Threshold: uint16(threshold), Palette: palette}.Dither
+	case "screen":
+		screen := dither.Screen{Size: size, Palette: palette}
+		if shape, ok := s.Params["shape"]; ok {
+			if screen.Shape, ok = dither.DotShapes[strings.ToLower(shape)]; !ok {
+				return nil, fmt.Errorf("glitch: step %q: unknown dot shape %q", s.Name, shape)
+			}
+		}
+		if inks, ok := s.Params["inks"]; ok {
+			if screen.Separation, ok = dither.Separations[strings.ToLower(inks)]; !ok {
+				return nil, fmt.Errorf("glitch: step %q: unknown inks %q", s.Name, inks)
+			}
+		}
+		if angles, ok := s.Params["angles"]; ok {
+			for _, field := range strings.Split(angles, ",") {
+				angle, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+				if err != nil {
+					return nil, fmt.Errorf("glitch: step %q: bad angle %q", s.Name, field)
+				}
+				screen.Angles = append(screen.Angles, angle)
+			}
+		}
+		apply = screen.Dither
+	default:
+		if matrix, ok := dither.Matrices[name]; ok {
+			apply = dither.Ordered{
+				Matrix:     matrix(int(size)),
+				Palette:    palette,
+				PerChannel: s.Params["perchannel"] == "true",
+			}.Dither
+			break
+		}
+		kernel, ok := dither.Kernels[name]
+		if !ok {
+			return nil, fmt.Errorf("glitch: step %q: unknown dither %q", s.Name, name)
+		}
+		broken := s.Params["broken"] == "true"
+		if broken && name == "atkinsons" {
+			// The same as dither.BrokenAtkinsons
+			kernel = dither.BrokenAtkinsonKernel
+		}
+		apply = dither.Diffuser{
+			Kernel:     kernel,
+			Palette:    palette,
+			Threshold:  uint8(threshold),
+			Serpentine: s.Params["serpentine"] == "true",
+			Broken:     broken,
+		}.Dither
+	}
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		apply(current)
+		return nil
+	}, nil
+}
+
+// brightness N
+func buildBrightnessStep(s Step) (stepRunner, error) {
+	brightnessFactor, err := s.factor("factor", 0, 5.0)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		effects.ApplyBrightness(current, brightnessFactor)
+		return nil
+	}, nil
+}
+
+// vhs [intensity=N]
+func buildVHSStep(s Step) (stepRunner, error) {
+	intensity, err := s.factor("intensity", 0, 50.0)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		return effects.VHSContext(ctx, rng, current, current, VHSOptions(current.Bounds(), intensity))
+	}, nil
+}
+
+// scanlines [period=N] [thickness=N] [phase=N] [opacity=N] [blend=normal|multiply|screen]
+// [color=RRGGBB] [orientation=horizontal|vertical|diagonal] [jitter=N]
+func buildScanlinesStep(s Step) (stepRunner, error) {
+	opts := effects.DefaultScanlines()
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"period", &opts.Period},
+		{"thickness", &opts.Thickness},
+		{"phase", &opts.Phase},
+		{"jitter", &opts.Jitter},
+	}
+	for _, param := range ints {
+		f, err := s.float(param.name, -1, float64(*param.value))
+		if err != nil {
+			return nil, err
+		}
+		*param.value = int(f)
+	}
+	if opts.Period < 1 {
+		return nil, fmt.Errorf("glitch: step %q: period must be at least 1", s.Name)
+	}
+
+	var err error
+	if opts.Opacity, err = s.float("opacity", -1, opts.Opacity); err != nil {
+		return nil, err
+	}
+	if !(opts.Opacity >= 0.0 && opts.Opacity <= 1.0) {
+		return nil, fmt.Errorf("glitch: step %q: opacity must be between 0 and 1", s.Name)
+	}
+	if name, ok := s.Params["blend"]; ok {
+		if opts.Blend, ok = effects.BlendModes[strings.ToLower(name)]; !ok {
+			return nil, fmt.Errorf("glitch: step %q: unknown blend mode %q", s.Name, name)
+		}
+	}
+	if name, ok := s.Params["orientation"]; ok {
+		if opts.Orientation, ok = effects.ScanlineOrientations[strings.ToLower(name)]; !ok {
+			return nil, fmt.Errorf("glitch: step %q: unknown orientation %q", s.Name, name)
+		}
+	}
+	if value, ok := s.Params["color"]; ok {
+		c, err := utils.ParseHexColor(value)
+		if err != nil {
+			return nil, fmt.Errorf("glitch: step %q: %w", s.Name, err)
+		}
+		opts.Color = c
+	}
+
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		effects.Scanlines(current, opts)
+		return nil
+	}, nil
+}
+
+// crt [aperture|shadow|slot|none] [darkness=N] [thickness=N] [period=N]
+// [strength=N] [size=N] [curvature=N] [vignette=N] [bloom=N] [corners=N]
+// where period is up to 256, size up to 64 and the rest are from 0 to 1
+func buildCRTStep(s Step) (stepRunner, error) {
+	opts := effects.DefaultCRT()
+	name := strings.ToLower(s.arg(0, "aperture"))
+	var ok bool
+	if opts.Mask, ok = effects.PhosphorMasks[name]; !ok {
+		return nil, fmt.Errorf("glitch: step %q: unknown phosphor mask %q", s.Name, name)
+	}
+
+	size := float64(opts.MaskSize)
+	for _, param := range []struct {
+		name  string
+		value *float64
+		max   float64
+	}{
+		{"darkness", &opts.ScanlineDarkness, 1},
+		{"thickness", &opts.ScanlineThickness, 1},
+		{"period", &opts.ScanlinePeriod, 256},
+		{"strength", &opts.MaskStrength, 1},
+		{"size", &size, 64},
+		{"curvature", &opts.Curvature, 1},
+		{"vignette", &opts.Vignette, 1},
+		{"bloom", &opts.Bloom, 1},
+		{"corners", &opts.Corners, 1},
+	} {
+		var err error
+		if *param.value, err = s.float(param.name, -1, *param.value); err != nil {
+			return nil, err
+		}
+		if !(*param.value >= 0 && *param.value <= param.max) {
+			return nil, fmt.Errorf("glitch: step %q: %s must be between 0 and %g", s.Name, param.name, param.max)
+		}
+	}
+	opts.MaskSize = int(size)
+
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		return effects.CRTContext(ctx, current, current, opts)
+	}, nil
+}
+
+// pixelsort [brightness|hue|saturation] [angle=N] [lower=N] [upper=N] [reverse=true]
+func buildPixelSortStep(s Step) (stepRunner, error) {
+	opts := effects.PixelSortOptions{Reverse: s.Params["reverse"] == "true"}
+	switch key := strings.ToLower(s.arg(0, "brightness")); key {
+	case "brightness":
+		opts.Key = effects.SortBrightness
+	case "hue":
+		opts.Key = effects.SortHue
+	case "saturation":
+		opts.Key = effects.SortSaturation
+	default:
+		return nil, fmt.Errorf("glitch: step %q: unknown sort key %q", s.Name, key)
+	}
+
+	var err error
+	if opts.Angle, err = s.float("angle", -1, 0); err != nil {
+		return nil, err
+	}
+	if opts.Lower, err = s.float("lower", -1, 0.25); err != nil {
+		return nil, err
+	}
+	if opts.Upper, err = s.float("upper", -1, 1); err != nil {
+		return nil, err
+	}
+	if !(opts.Lower >= 0 && opts.Upper <= 1 && opts.Lower <= opts.Upper) {
+		return nil, fmt.Errorf("glitch: step %q: lower and upper must be between 0 and 1, lower first", s.Name)
+	}
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		return effects.PixelSortContext(ctx, current, opts)
+	}, nil
+}
+
+// channelshift [red=X,Y] [green=X,Y] [blue=X,Y] [radial=R,G,B] [edge=wrap|clamp|transparent]
+func buildChannelShiftStep(s Step) (stepRunner, error) {
+	var opts effects.ChannelShiftOptions
+	if edge, ok := s.Params["edge"]; ok {
+		if opts.Edge, ok = effects.EdgeModes[strings.ToLower(edge)]; !ok {
+			return nil, fmt.Errorf("glitch: step %q: unknown edge mode %q", s.Name, edge)
+		}
+	}
+
+	// floats parses a comma separated list of exactly n numbers
+	floats := func(name string, n int) ([]float64, error) {
+		value, ok := s.Params[name]
+		if !ok {
+			return make([]float64, n), nil
+		}
+		fields := strings.Split(value, ",")
+		if len(fields) != n {
+			return nil, fmt.Errorf("glitch: step %q: %s needs %d values", s.Name, name, n)
+		}
+		values := make([]float64, n)
+		for i, field := range fields {
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, fmt.Errorf("glitch: step %q: bad %s %q", s.Name, name, value)
+			}
+			values[i] = v
+		}
+		return values, nil
+	}
+
+	for c, name := range []string{"red", "green", "blue"} {
+		offset, err := floats(name, 2)
+		if err != nil {
+			return nil, err
+		}
+		opts.Offsets[c] = image.Pt(int(offset[0]), int(offset[1]))
+	}
+	radial, err := floats("radial", 3)
+	if err != nil {
+		return nil, err
+	}
+	for c, r := range radial {
+		if r <= -1 {
+			return nil, fmt.Errorf("glitch: step %q: radial must be greater than -1", s.Name)
+		}
+		opts.Radial[c] = r
+	}
+
+	return func(ctx context.Context, rng utils.Rand, original, current *image.RGBA) error {
+		return effects.ChannelShiftContext(ctx, current, cloneRGBA(current), opts)
+	}, nil
+}
@@ -0,0 +1,166 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// MedianCut is a draw.Quantizer that builds a palette by repeatedly splitting
+// the colour space of the image at the median of its widest channel
+type MedianCut struct{}
+
+// histBin accumulates the pixels falling into one cell of a 5 bits per channel
+// colour histogram
+type histBin struct {
+	r, g, b uint64
+	count   uint64
+	key     [3]uint8
+}
+
+func (b *histBin) mean() color.RGBA {
+	return color.RGBA{
+		R: uint8(b.r / b.count),
+		G: uint8(b.g / b.count),
+		B: uint8(b.b / b.count),
+		A: 0xff,
+	}
+}
+
+// box is a set of histogram bins that will become one palette entry
+type box struct {
+	bins  []*histBin
+	count uint64
+}
+
+// widest returns the channel with the largest range in the box and its range
+func (b *box) widest() (channel int, spread int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := uint8(0xff), uint8(0)
+		for _, bin := range b.bins {
+			if bin.key[c] < lo {
+				lo = bin.key[c]
+			}
+			if bin.key[c] > hi {
+				hi = bin.key[c]
+			}
+		}
+		if int(hi)-int(lo) > spread {
+			channel, spread = c, int(hi)-int(lo)
+		}
+	}
+	return channel, spread
+}
+
+// split divides the box in two at the weighted median of its widest channel
+func (b *box) split() (*box, *box) {
+	channel, _ := b.widest()
+	sort.SliceStable(b.bins, func(i, j int) bool { return b.bins[i].key[channel] < b.bins[j].key[channel] })
+
+	var seen uint64
+	at := 1
+	for i, bin := range b.bins[:len(b.bins)-1] {
+		seen += bin.count
+		at = i + 1
+		if seen*2 >= b.count {
+			break
+		}
+	}
+
+	lo, hi := &box{bins: b.bins[:at]}, &box{bins: b.bins[at:]}
+	for _, bin := range lo.bins {
+		lo.count += bin.count
+	}
+	hi.count = b.count - lo.count
+	return lo, hi
+}
+
+// Quantize appends up to cap(p)-len(p) colours that best represent m to p.
+// If m has any transparent pixels, one of those colours is transparent.
+func (MedianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+
+	histogram := make(map[[3]uint8]*histBin)
+	transparent := false
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A < 0x80 {
+				transparent = true
+				continue
+			}
+			key := [3]uint8{c.R >> 3, c.G >> 3, c.B >> 3}
+			entry := histogram[key]
+			if entry == nil {
+				entry = &histBin{key: key}
+				histogram[key] = entry
+			}
+			entry.r += uint64(c.R)
+			entry.g += uint64(c.G)
+			entry.b += uint64(c.B)
+			entry.count++
+		}
+	}
+
+	if transparent {
+		p = append(p, color.RGBA{})
+		n--
+	}
+	if n <= 0 || len(histogram) == 0 {
+		return p
+	}
+
+	all := &box{}
+	for _, bin := range histogram {
+		all.bins = append(all.bins, bin)
+		all.count += bin.count
+	}
+	// Start from a fixed order so the same image always gets the same palette
+	sort.Slice(all.bins, func(i, j int) bool {
+		a, b := all.bins[i].key, all.bins[j].key
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+
+	boxes := []*box{all}
+	for len(boxes) < n {
+		// Split whichever box covers the widest range, favouring busy boxes
+		best, bestScore := -1, uint64(0)
+		for i, b := range boxes {
+			if len(b.bins) < 2 {
+				continue
+			}
+			_, spread := b.widest()
+			if score := uint64(spread+1) * b.count; score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		lo, hi := boxes[best].split()
+		boxes[best] = lo
+		boxes = append(boxes, hi)
+	}
+
+	for _, b := range boxes {
+		var merged histBin
+		for _, bin := range b.bins {
+			merged.r += bin.r
+			merged.g += bin.g
+			merged.b += bin.b
+			merged.count += bin.count
+		}
+		p = append(p, merged.mean())
+	}
+	return p
+}
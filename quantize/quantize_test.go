@@ -0,0 +1,78 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gradient returns an image with far more colours than any palette
+func gradient() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), uint8((x + y) * 2), 0xff})
+		}
+	}
+	return img
+}
+
+func TestMedianCutColourCount(t *testing.T) {
+	img := gradient()
+	few := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range few.Pix {
+		few.Pix[i] = 0xff
+	}
+	transparent := gradient()
+	transparent.Set(0, 0, color.RGBA{})
+
+	tests := []struct {
+		name  string
+		img   image.Image
+		n     int
+		exact bool
+	}{
+		{"2 colours", img, 2, true},
+		{"16 colours", img, 16, true},
+		{"256 colours", img, 256, true},
+		{"1 colour in the image", few, 16, false},
+		{"transparent", transparent, 8, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := MedianCut{}.Quantize(make(color.Palette, 0, tt.n), tt.img)
+			if len(p) > tt.n || (tt.exact && len(p) != tt.n) {
+				t.Errorf("got %d colours, want %d", len(p), tt.n)
+			}
+		})
+	}
+}
+
+func TestMedianCutTransparent(t *testing.T) {
+	img := gradient()
+	img.Set(3, 3, color.RGBA{})
+	p := MedianCut{}.Quantize(make(color.Palette, 0, 8), img)
+	found := false
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("no transparent colour in the palette")
+	}
+}
+
+func TestMedianCutKeepsPalette(t *testing.T) {
+	p := color.Palette{color.RGBA{1, 2, 3, 0xff}}
+	p = append(make(color.Palette, 0, 4), p...)
+	got := MedianCut{}.Quantize(p, gradient())
+	if len(got) > 4 || got[0] != (color.RGBA{1, 2, 3, 0xff}) {
+		t.Errorf("got %v, want the first colour kept and at most 4", got)
+	}
+
+	full := make(color.Palette, 2)
+	if got := (MedianCut{}).Quantize(full, gradient()); len(got) != 2 {
+		t.Errorf("full palette grew to %d colours", len(got))
+	}
+}
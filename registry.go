@@ -0,0 +1,257 @@
+package glitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/darkliquid/glitch/databend"
+	"github.com/darkliquid/glitch/effects"
+	"github.com/darkliquid/glitch/utils"
+)
+
+// DefaultMode is the algorithm used when Options.Mode is empty
+const DefaultMode = "wtf"
+
+// ErrUnknownMode is returned when Options.Mode names an unregistered algorithm
+var ErrUnknownMode = errors.New("glitch: unknown mode")
+
+// Algorithm is a glitching algorithm. Glitch reads from input and writes the
+// glitched result to output, which starts off as a copy of input. All
+// randomness must come from rng so that runs can be reproduced.
+type Algorithm interface {
+	Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error
+}
+
+// AlgorithmFunc lets an ordinary function be used as an Algorithm
+type AlgorithmFunc func(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error
+
+// Glitch calls f(ctx, rng, input, output, glitchFactor)
+func (f AlgorithmFunc) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	return f(ctx, rng, input, output, glitchFactor)
+}
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = make(map[string]Algorithm)
+)
+
+// Register makes an algorithm available by the provided name. It panics if
+// Register is called twice with the same name or if the algorithm is nil.
+func Register(name string, algorithm Algorithm) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	if algorithm == nil {
+		panic("glitch: Register algorithm is nil")
+	}
+	if _, dup := algorithms[name]; dup {
+		panic("glitch: Register called twice for algorithm " + name)
+	}
+	algorithms[name] = algorithm
+}
+
+// Lookup returns the algorithm registered under name
+func Lookup(name string) (Algorithm, bool) {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	algorithm, ok := algorithms[name]
+	return algorithm, ok
+}
+
+// Modes returns a sorted list of the names of the registered algorithms
+func Modes() []string {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// airtightAlgorithm is the imageglitcher algorithm from airtight interactive
+type airtightAlgorithm struct{}
+
+func (airtightAlgorithm) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	return imageglitcher(ctx, rng, input, output, input.Bounds(), glitchFactor)
+}
+
+func (airtightAlgorithm) Plan(rng utils.Rand, bounds image.Rectangle, glitchFactor float64) *Trace {
+	trace := &Trace{Width: bounds.Dx(), Height: bounds.Dy(), GlitchFactor: glitchFactor}
+	planImageglitcher(rng, bounds, glitchFactor, trace)
+	return trace
+}
+
+func (airtightAlgorithm) Replay(ctx context.Context, trace *Trace, input, output *image.RGBA) error {
+	return replayImageglitcher(ctx, trace, input, output)
+}
+
+// tearAlgorithm is like airtightAlgorithm, but tears the image vertically and
+// diagonally with ragged slices and rippling offsets too
+type tearAlgorithm struct{}
+
+func (tearAlgorithm) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	trace := &Trace{}
+	planTear(rng, input.Bounds(), glitchFactor, trace)
+	return replayImageglitcher(ctx, trace, input, output)
+}
+
+func (tearAlgorithm) Plan(rng utils.Rand, bounds image.Rectangle, glitchFactor float64) *Trace {
+	trace := &Trace{Width: bounds.Dx(), Height: bounds.Dy(), GlitchFactor: glitchFactor}
+	planTear(rng, bounds, glitchFactor, trace)
+	return trace
+}
+
+func (tearAlgorithm) Replay(ctx context.Context, trace *Trace, input, output *image.RGBA) error {
+	return replayImageglitcher(ctx, trace, input, output)
+}
+
+// wtfAlgorithm layers randomly dithered, sliced and channel-copied buffers
+type wtfAlgorithm struct{}
+
+func (wtfAlgorithm) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	return wtfify(ctx, rng, input, output, input.Bounds(), glitchFactor)
+}
+
+func (wtfAlgorithm) Plan(rng utils.Rand, bounds image.Rectangle, glitchFactor float64) *Trace {
+	return planWtfify(rng, bounds, glitchFactor)
+}
+
+func (wtfAlgorithm) Replay(ctx context.Context, trace *Trace, input, output *image.RGBA) error {
+	return replayWtfify(ctx, trace, input, output)
+}
+
+// pixelSortAlgorithm sorts runs of pixels by a random key and direction
+type pixelSortAlgorithm struct{}
+
+func (pixelSortAlgorithm) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	// The more glitching, the wider the range of pixels that get sorted,
+	// from the brightest down to all of them
+	if glitchFactor <= 0 {
+		return nil
+	}
+	return effects.PixelSortContext(ctx, output, effects.PixelSortOptions{
+		Key:   effects.SortKey(utils.Random(rng, 0, 3)),
+		Angle: float64(90 * utils.Random(rng, 0, 2)),
+		Lower: 1 - glitchFactor/100,
+		Upper: 1,
+	})
+}
+
+// datamoshAlgorithm displaces and smears macroblocks like a broken video codec
+type datamoshAlgorithm struct{}
+
+func (datamoshAlgorithm) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	return effects.DatamoshContext(ctx, rng, output, input, nil, DatamoshOptions(input.Bounds(), glitchFactor))
+}
+
+// DatamoshOptions returns datamosh settings scaled by the glitch factor
+func DatamoshOptions(bounds image.Rectangle, glitchFactor float64) effects.DatamoshOptions {
+	amount := glitchFactor / 100.0
+	return effects.DatamoshOptions{
+		BlockSize:    16,
+		MaxOffset:    int(amount*float64(bounds.Dx())/4) + 8,
+		Displace:     math.Min(1, amount*4),
+		Repeat:       math.Min(1, amount*2),
+		RepeatLength: 4,
+		Stale:        math.Min(1, amount*4),
+	}
+}
+
+// channelShiftAlgorithm splits the colour channels apart like a misaligned
+// projector or a cheap lens
+type channelShiftAlgorithm struct{}
+
+func (channelShiftAlgorithm) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	return effects.ChannelShiftContext(ctx, output, input, randomChannelShift(rng, input.Bounds(), glitchFactor))
+}
+
+// randomChannelShift picks channel offsets scaled by the glitch factor. A
+// third of the time the split is radial, like lens aberration, instead.
+func randomChannelShift(rng utils.Rand, bounds image.Rectangle, glitchFactor float64) effects.ChannelShiftOptions {
+	amount := glitchFactor / 100.0
+	maxOffset := int(amount*float64(bounds.Dx())/8) + 1
+	opts := effects.ChannelShiftOptions{Edge: effects.EdgeMode(utils.Random(rng, 0, 3))}
+
+	if utils.Random(rng, 0, 3) == 0 {
+		// Pull red and blue in opposite directions around green
+		spread := float64(rng.Float32()) * (amount/4 + 0.01)
+		opts.Radial = [3]float64{spread, 0, -spread}
+		return opts
+	}
+	for c := range opts.Offsets {
+		opts.Offsets[c] = image.Pt(
+			utils.Random(rng, -maxOffset, maxOffset+1),
+			utils.Random(rng, -maxOffset/2, maxOffset/2+1),
+		)
+	}
+	return opts
+}
+
+// vhsAlgorithm plays the image back from a worn out video tape
+type vhsAlgorithm struct{}
+
+func (vhsAlgorithm) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	return effects.VHSContext(ctx, rng, output, input, VHSOptions(input.Bounds(), glitchFactor))
+}
+
+// VHSOptions returns VHS settings for an intensity from 0 to 100
+func VHSOptions(bounds image.Rectangle, intensity float64) effects.VHSOptions {
+	amount := intensity / 100.0
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	return effects.VHSOptions{
+		ChromaBlur:    int(2 + amount*width/40),
+		ChromaShift:   int(1 + amount*width/100),
+		Ghost:         0.1 + amount*0.3,
+		GhostOffset:   int(2 + amount*width/50),
+		TrackingBands: int(1 + amount*4),
+		TrackingShift: int(2 + amount*width/8),
+		HeadSwitch:    int(2 + height/60 + amount*height/30),
+		Dropouts:      amount * 0.05,
+		Noise:         0.02 + amount*0.1,
+	}
+}
+
+// databendTries is how many times to re-corrupt data that won't decode
+const databendTries = 32
+
+// databendAlgorithm re-encodes the image as a JPEG and corrupts the
+// compressed data, for real compression artefacts
+type databendAlgorithm struct{}
+
+func (databendAlgorithm) Glitch(ctx context.Context, rng utils.Rand, input, output *image.RGBA, glitchFactor float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, input, &jpeg.Options{Quality: jpeg.DefaultQuality}); err != nil {
+		return err
+	}
+	bent, err := databend.DecodeContext(ctx, encoded.Bytes(), rng, 1+int(glitchFactor), databendTries)
+	if err != nil {
+		return err
+	}
+
+	bounds := output.Bounds()
+	draw.Draw(output, bounds, bent, bent.Bounds().Min, draw.Src)
+	return nil
+}
+
+func init() {
+	Register("airtight", airtightAlgorithm{})
+	Register("wtf", wtfAlgorithm{})
+	Register("pixelsort", pixelSortAlgorithm{})
+	Register("databend", databendAlgorithm{})
+	Register("datamosh", datamoshAlgorithm{})
+	Register("channelshift", channelShiftAlgorithm{})
+	Register("tear", tearAlgorithm{})
+	Register("vhs", vhsAlgorithm{})
+}
@@ -0,0 +1,202 @@
+package glitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+
+	"github.com/darkliquid/glitch/effects"
+	"github.com/darkliquid/glitch/utils"
+)
+
+// ErrNotTraceable is returned when recording or replaying a trace with an
+// algorithm that doesn't implement Tracer
+var ErrNotTraceable = errors.New("glitch: algorithm does not support traces")
+
+// Tracer is implemented by algorithms whose random choices can be recorded
+// and replayed. Plan makes every random choice up front without touching any
+// pixels, and Replay applies a plan to an image.
+type Tracer interface {
+	Algorithm
+	Plan(rng utils.Rand, bounds image.Rectangle, glitchFactor float64) *Trace
+	Replay(ctx context.Context, trace *Trace, input, output *image.RGBA) error
+}
+
+// Trace is a record of the random choices made while glitching an image
+type Trace struct {
+	// Mode is the algorithm the trace was recorded with
+	Mode string `json:"mode"`
+	// Width and Height are the size of the image the trace was recorded on.
+	// Replaying onto a different size scales the slices to match.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// GlitchFactor is the glitch factor the trace was recorded with
+	GlitchFactor float64 `json:"glitch_factor"`
+	// Thresholds holds the dither thresholds used to build the wtf sources
+	Thresholds map[string]int `json:"thresholds,omitempty"`
+	// Transforms are the wtf transforms, in the order they were applied
+	Transforms []TraceTransform `json:"transforms,omitempty"`
+	// Slices are the slice offsets of the final airtight pass
+	Slices []TraceSlice `json:"slices"`
+	// Channel is the channel copied back from the input by the airtight pass
+	Channel utils.Channel `json:"channel"`
+}
+
+// TraceTransform records one transform applied by the wtf algorithm
+type TraceTransform struct {
+	Index     int          `json:"index"`
+	Name      string       `json:"name"`
+	Src       string       `json:"src"`
+	Dest      string       `json:"dest"`
+	Threshold int          `json:"threshold,omitempty"`
+	Angle     float64      `json:"angle,omitempty"`
+	Slices    []TraceSlice `json:"slices,omitempty"`
+	// The pixelate transform's block size, quantisation and per-channel
+	// thresholds
+	BlockWidth  int   `json:"block_width,omitempty"`
+	BlockHeight int   `json:"block_height,omitempty"`
+	Sample      bool  `json:"sample,omitempty"`
+	Levels      int   `json:"levels,omitempty"`
+	Thresholds  []int `json:"thresholds,omitempty"`
+	// The channelShift transform's red, green and blue offsets, radial
+	// scales and edge mode
+	ChannelDX []int            `json:"channel_dx,omitempty"`
+	ChannelDY []int            `json:"channel_dy,omitempty"`
+	Radial    []float64        `json:"radial,omitempty"`
+	Edge      effects.EdgeMode `json:"edge,omitempty"`
+}
+
+// TraceSlice records one slice moved by effects.WrapSlice. Y is measured from
+// the top of the image, whatever its bounds, so traces work on sub images.
+// Slices with an angle, shape or curve are moved by effects.ShiftSlice
+// instead, with Y and Height measured across the slice.
+type TraceSlice struct {
+	Y      int                 `json:"y"`
+	Height int                 `json:"height"`
+	Offset int                 `json:"offset"`
+	Angle  float64             `json:"angle,omitempty"`
+	Shape  effects.SliceShape  `json:"shape,omitempty"`
+	Curve  effects.OffsetCurve `json:"curve,omitempty"`
+	Period float64             `json:"period,omitempty"`
+}
+
+// straight reports whether the slice is a plain horizontal band
+func (s TraceSlice) straight() bool {
+	return s.Angle == 0 && s.Shape == effects.SliceStraight && s.Curve == effects.CurveUniform
+}
+
+// shiftSlice moves one traced slice of in onto out
+func shiftSlice(out, in *image.RGBA, s TraceSlice, mask image.Image, op draw.Op) {
+	if s.straight() {
+		effects.WrapSlice(out, in, s.Offset, in.Bounds().Min.Y+s.Y, s.Height, mask, op)
+		return
+	}
+	effects.ShiftSlice(out, in, effects.SliceOptions{
+		Angle:     s.Angle,
+		Position:  s.Y,
+		Thickness: s.Height,
+		Offset:    s.Offset,
+		Shape:     s.Shape,
+		Curve:     s.Curve,
+		Period:    s.Period,
+	}, mask, op)
+}
+
+// ReadTrace decodes a JSON trace
+func ReadTrace(r io.Reader) (*Trace, error) {
+	var trace Trace
+	if err := json.NewDecoder(r).Decode(&trace); err != nil {
+		return nil, fmt.Errorf("glitch: couldn't decode trace: %w", err)
+	}
+	return &trace, nil
+}
+
+// LoadTraceFile reads a JSON trace from the named file
+func LoadTraceFile(name string) (*Trace, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTrace(f)
+}
+
+// WriteTo encodes the trace as JSON
+func (t *Trace) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// SaveFile writes the trace as JSON to the named file
+func (t *Trace) SaveFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := t.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// scaled returns a copy of the trace with its slices scaled to fit bounds
+func (t *Trace) scaled(bounds image.Rectangle) *Trace {
+	width, height := bounds.Dx(), bounds.Dy()
+	if (t.Width == width && t.Height == height) || t.Width <= 0 || t.Height <= 0 {
+		return t
+	}
+
+	// Angled slices don't line up with either side, so scale them by the
+	// diagonal instead
+	diagonal := math.Hypot(float64(width), float64(height)) / math.Hypot(float64(t.Width), float64(t.Height))
+	scaleSlices := func(slices []TraceSlice) []TraceSlice {
+		out := make([]TraceSlice, len(slices))
+		for i, s := range slices {
+			out[i] = s
+			if s.straight() {
+				out[i].Y = s.Y * height / t.Height
+				out[i].Height = s.Height * height / t.Height
+				out[i].Offset = s.Offset * width / t.Width
+			} else {
+				out[i].Y = int(float64(s.Y) * diagonal)
+				out[i].Height = int(float64(s.Height) * diagonal)
+				out[i].Offset = int(float64(s.Offset) * diagonal)
+				out[i].Period = s.Period * diagonal
+			}
+			if s.Height > 0 && out[i].Height == 0 {
+				out[i].Height = 1
+			}
+		}
+		return out
+	}
+
+	scaled := *t
+	scaled.Width, scaled.Height = width, height
+	scaled.Slices = scaleSlices(t.Slices)
+	scaled.Transforms = make([]TraceTransform, len(t.Transforms))
+	scaleInts := func(values []int, size, from int) []int {
+		out := make([]int, len(values))
+		for i, v := range values {
+			out[i] = v * size / from
+		}
+		return out
+	}
+	for i, transform := range t.Transforms {
+		transform.Slices = scaleSlices(transform.Slices)
+		transform.ChannelDX = scaleInts(transform.ChannelDX, width, t.Width)
+		transform.ChannelDY = scaleInts(transform.ChannelDY, height, t.Height)
+		scaled.Transforms[i] = transform
+	}
+	return &scaled
+}
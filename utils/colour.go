@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Luminance returns the perceived brightness of a colour in the range 0-1
+func Luminance(r, g, b uint8) float64 {
+	return (.299*float64(r) + .587*float64(g) + .114*float64(b)) / 255
+}
+
+// HSV converts a colour to hue, saturation and value, each in the range 0-1
+func HSV(r, g, b uint8) (h, s, v float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	delta := max - min
+
+	v = max
+	if max > 0 {
+		s = delta / max
+	}
+	if delta == 0 {
+		return 0, s, v
+	}
+
+	switch max {
+	case rf:
+		h = (gf - bf) / delta
+		if h < 0 {
+			h += 6
+		}
+	case gf:
+		h = (bf-rf)/delta + 2
+	default:
+		h = (rf-gf)/delta + 4
+	}
+	return h / 6, s, v
+}
+
+// ParseHexColor parses an opaque colour written as RRGGBB, with or without a
+// leading #
+func ParseHexColor(s string) (color.RGBA, error) {
+	rgb, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	if err != nil || len(rgb) != 3 {
+		return color.RGBA{}, fmt.Errorf("bad hex colour %q", s)
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 0xff}, nil
+}
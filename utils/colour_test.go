@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.RGBA
+		err  bool
+	}{
+		{in: "#ff8000", want: color.RGBA{0xff, 0x80, 0x00, 0xff}},
+		{in: "FF8000", want: color.RGBA{0xff, 0x80, 0x00, 0xff}},
+		{in: " 00ff00 ", want: color.RGBA{0x00, 0xff, 0x00, 0xff}},
+		{in: "#000000", want: color.RGBA{0, 0, 0, 0xff}},
+		{in: "fff", err: true},
+		{in: "#fff", err: true},
+		{in: "zz0000", err: true},
+		{in: "ff00001", err: true},
+		{in: "ff000000", err: true},
+		{in: "##ff0000", err: true},
+		{in: "", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseHexColor(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
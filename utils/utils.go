@@ -1,9 +1,15 @@
 package utils
 
-import "math/rand"
+// Rand is a source of random numbers. *math/rand.Rand satisfies it.
+// Implementations don't need to be safe for concurrent use, as each
+// glitch run is expected to use its own source.
+type Rand interface {
+	Intn(n int) int
+	Float32() float32
+}
 
 // Random spits out a random int between min and max
-func Random(min, max int) int {
+func Random(rng Rand, min, max int) int {
 	offset := 0
 	input := max - min
 
@@ -13,12 +19,12 @@ func Random(min, max int) int {
 		input = offset
 	}
 
-	return rand.Intn(input) + min - offset
+	return rng.Intn(input) + min - offset
 }
 
 // RandomChannel picks a random colour channel (excludes ALPHA, since that's usually boring)
-func RandomChannel() Channel {
-	r := rand.Float32()
+func RandomChannel(rng Rand) Channel {
+	r := rng.Float32()
 	if r < 0.33 {
 		return Green
 	} else if r < 0.66 {